@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/andygrunwald/go-gerrit"
+)
+
+// defaultAccountsPageSize is the number of Gerrit accounts requested per page
+// when prefetching accounts.
+const defaultAccountsPageSize = 500
+
+// gerritAccountIndex is an in-memory index of Gerrit accounts, built once per
+// pass so that users can be matched without querying Gerrit for each of them.
+type gerritAccountIndex struct {
+	// emails normalizes the keys of byEmail.
+	emails emailNormalizer
+
+	// byEmail maps normalized emails, preferred and secondary, to the accounts
+	// that registered them.
+	byEmail map[string][]gerrit.AccountInfo
+
+	// byUsername maps usernames to the account that has it.
+	byUsername map[string][]gerrit.AccountInfo
+
+	// all lists every indexed account.
+	all []gerrit.AccountInfo
+
+	// bySubject maps OIDC subjects to the accounts with an external ID
+	// holding them. It is nil until indexExternalIDs is called.
+	bySubject map[string][]gerrit.AccountInfo
+}
+
+// loadGerritAccountIndex pages through the Gerrit accounts matching each of
+// queries, pageSize accounts at a time, and indexes them by emails normalized
+// with emails.
+func loadGerritAccountIndex(ctx context.Context, svc gerritAccountsService, queries []string, pageSize int, emails emailNormalizer) (*gerritAccountIndex, error) {
+	idx := newGerritAccountIndex(emails)
+	seen := map[int]bool{}
+
+	for _, query := range queries {
+		for start := 0; ; {
+			gus, _, err := svc.QueryAccounts(ctx, &gerrit.QueryAccountOptions{
+				QueryOptions: gerrit.QueryOptions{
+					Query: []string{query},
+					Limit: pageSize,
+				},
+				Start: start,
+				AccountOptions: gerrit.AccountOptions{
+					AdditionalFields: []string{"DETAILS", "ALL_EMAILS"},
+				},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("query Gerrit accounts %q from %d: %w", query, start, err)
+			}
+
+			for _, gu := range *gus {
+				if seen[gu.AccountID] {
+					continue
+				}
+				seen[gu.AccountID] = true
+				idx.add(gu)
+			}
+
+			if len(*gus) == 0 || !(*gus)[len(*gus)-1].MoreAccounts {
+				break
+			}
+			start += len(*gus)
+		}
+	}
+
+	log.Printf("Indexed %d Gerrit accounts by %d emails", len(idx.all), len(idx.byEmail))
+	return idx, nil
+}
+
+// newGerritAccountIndex returns an empty index of emails normalized with
+// emails.
+func newGerritAccountIndex(emails emailNormalizer) *gerritAccountIndex {
+	return &gerritAccountIndex{
+		emails:     emails,
+		byEmail:    map[string][]gerrit.AccountInfo{},
+		byUsername: map[string][]gerrit.AccountInfo{},
+	}
+}
+
+// add indexes gu under each of its emails and its username.
+func (idx *gerritAccountIndex) add(gu gerrit.AccountInfo) {
+	// The flag only describes the position of gu in its page.
+	gu.MoreAccounts = false
+	idx.all = append(idx.all, gu)
+	if gu.Username != "" {
+		idx.byUsername[gu.Username] = append(idx.byUsername[gu.Username], gu)
+	}
+
+	emails := map[string]bool{}
+	for _, email := range append([]string{gu.Email}, gu.SecondaryEmails...) {
+		if email == "" {
+			continue
+		}
+		email = idx.emails.normalize(email)
+		if emails[email] {
+			continue
+		}
+		emails[email] = true
+		idx.byEmail[email] = append(idx.byEmail[email], gu)
+	}
+}
+
+// lookupEmail returns the accounts that registered email, once normalized.
+func (idx *gerritAccountIndex) lookupEmail(email string) []gerrit.AccountInfo {
+	if email == "" {
+		return nil
+	}
+	return idx.byEmail[idx.emails.normalize(email)]
+}
+
+// indexExternalIDs reads the external IDs of every indexed account from svc,
+// and indexes the accounts by the OIDC subjects of oidc they hold. It takes a
+// request per account, so it is only done once per pass for matchExternalID.
+func (idx *gerritAccountIndex) indexExternalIDs(ctx context.Context, svc gerritAccountsService, oidc oidcIdentity) error {
+	bySubject := map[string][]gerrit.AccountInfo{}
+	for _, gu := range idx.all {
+		infos, _, err := svc.GetAccountExternalIDs(ctx, strconv.Itoa(gu.AccountID))
+		if err != nil {
+			return fmt.Errorf("get external IDs of Gerrit user %d: %w", gu.AccountID, err)
+		}
+		subjects := map[string]bool{}
+		for _, info := range *infos {
+			for _, subject := range oidc.subjects(info.Identity) {
+				if !subjects[subject] {
+					subjects[subject] = true
+					bySubject[subject] = append(bySubject[subject], gu)
+				}
+			}
+		}
+	}
+	idx.bySubject = bySubject
+	log.Printf("Indexed %d Gerrit accounts by %d OIDC subjects", len(idx.all), len(bySubject))
+	return nil
+}
+
+// lookupSubject returns the accounts with an external ID holding the OIDC
+// subject.
+func (idx *gerritAccountIndex) lookupSubject(subject string) []gerrit.AccountInfo {
+	return idx.bySubject[subject]
+}
+
+// lookupUsername returns the account with username.
+func (idx *gerritAccountIndex) lookupUsername(username string) []gerrit.AccountInfo {
+	return idx.byUsername[username]
+}
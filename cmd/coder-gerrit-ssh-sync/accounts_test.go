@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// pagedGerrit serves QueryAccounts from accounts per query, honoring the
+// page size and start offset like Gerrit does.
+type pagedGerrit struct {
+	*MockGerritClient
+	accounts map[string][]gerrit.AccountInfo
+	requests []gerrit.QueryAccountOptions
+	failAt   int
+}
+
+func (g *pagedGerrit) QueryAccounts(ctx context.Context, opts *gerrit.QueryAccountOptions) (*[]gerrit.AccountInfo, *gerrit.Response, error) {
+	g.requests = append(g.requests, *opts)
+	if g.failAt > 0 && len(g.requests) == g.failAt {
+		return nil, nil, errors.New("query failed")
+	}
+	all := g.accounts[opts.Query[0]]
+	start := min(opts.Start, len(all))
+	end := min(start+opts.Limit, len(all))
+	page := append([]gerrit.AccountInfo(nil), all[start:end]...)
+	if end < len(all) {
+		page[len(page)-1].MoreAccounts = true
+	}
+	return &page, &gerrit.Response{}, nil
+}
+
+func TestLoadGerritAccountIndex(t *testing.T) {
+	ctx := context.Background()
+	g := &pagedGerrit{
+		MockGerritClient: &MockGerritClient{},
+		accounts: map[string][]gerrit.AccountInfo{
+			"is:active": {
+				{AccountID: 1, Email: "Alice@Example.com", SecondaryEmails: []string{"alice@corp.example.com", "alice@example.com"}},
+				{AccountID: 2, Email: "bob@example.com", Username: "bob"},
+				{AccountID: 3, Email: "shared@example.com"},
+				{AccountID: 4, SecondaryEmails: []string{"shared@example.com"}},
+				{AccountID: 5},
+			},
+			"is:inactive": {
+				{AccountID: 6, Email: "carol@example.com", Inactive: true},
+				{AccountID: 2, Email: "bob@example.com"},
+			},
+		},
+	}
+
+	idx, err := loadGerritAccountIndex(ctx, g, []string{"is:active", "is:inactive"}, 2, emailNormalizer{})
+	if err != nil {
+		t.Fatalf("loadGerritAccountIndex() error = %v", err)
+	}
+
+	if len(idx.all) != 6 {
+		t.Errorf("indexed %d accounts, want 6", len(idx.all))
+	}
+	// 3 pages of active accounts and 1 page of inactive accounts.
+	if len(g.requests) != 4 {
+		t.Errorf("sent %d queries, want 4", len(g.requests))
+	}
+	for _, req := range g.requests {
+		if diff := cmp.Diff([]string{"DETAILS", "ALL_EMAILS"}, req.AdditionalFields); diff != "" {
+			t.Errorf("additional fields mismatch (-want +got):\n%s", diff)
+		}
+	}
+
+	testCases := []struct {
+		email    string
+		expected []int
+	}{
+		{email: "alice@example.com", expected: []int{1}},
+		{email: "ALICE@corp.example.com", expected: []int{1}},
+		{email: "bob@example.com", expected: []int{2}},
+		{email: "shared@example.com", expected: []int{3, 4}},
+		{email: "carol@example.com", expected: []int{6}},
+		{email: "nobody@example.com", expected: nil},
+	}
+	if got := idx.lookupUsername("bob"); len(got) != 1 || got[0].AccountID != 2 {
+		t.Errorf("lookupUsername(%q) = %+v, want account 2", "bob", got)
+	}
+
+	for _, tc := range testCases {
+		var got []int
+		for _, gu := range idx.lookupEmail(tc.email) {
+			if gu.MoreAccounts {
+				t.Errorf("account %d is indexed with _more_accounts set", gu.AccountID)
+			}
+			got = append(got, gu.AccountID)
+		}
+		if diff := cmp.Diff(tc.expected, got); diff != "" {
+			t.Errorf("lookupEmail(%q) mismatch (-want +got):\n%s", tc.email, diff)
+		}
+	}
+}
+
+func TestLoadGerritAccountIndexError(t *testing.T) {
+	g := &pagedGerrit{
+		MockGerritClient: &MockGerritClient{},
+		accounts: map[string][]gerrit.AccountInfo{
+			"is:active": {{AccountID: 1}, {AccountID: 2}, {AccountID: 3}},
+		},
+		failAt: 2,
+	}
+	if _, err := loadGerritAccountIndex(context.Background(), g, []string{"is:active"}, 2, emailNormalizer{}); err == nil {
+		t.Errorf("loadGerritAccountIndex() succeeded, want error")
+	}
+}
+
+func TestSyncUserWithAccountIndex(t *testing.T) {
+	idx := newGerritAccountIndex(emailNormalizer{})
+	idx.add(gerrit.AccountInfo{AccountID: 123, Email: "test@example.com"})
+
+	mockGerrit := &MockGerritClient{QueryErr: errors.New("must not query Gerrit")}
+	s := &syncer{gerrit: mockGerrit, accounts: idx}
+
+	user := &coderclient.CoderUser{Email: "Test@Example.com", ID: "user123", Username: "testUser1"}
+	got, err := s.findAccounts(context.Background(), user)
+	if err != nil {
+		t.Fatalf("findAccounts() error = %v", err)
+	}
+	if len(got) != 1 || got[0].AccountID != 123 {
+		t.Errorf("findAccounts() = %+v, want account 123", got)
+	}
+}
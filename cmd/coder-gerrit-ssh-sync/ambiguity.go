@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// ambiguityPolicy controls which Gerrit accounts are synchronized when a
+// Coder user matches several of them.
+type ambiguityPolicy string
+
+const (
+	// ambiguityAll synchronizes every matching account.
+	ambiguityAll ambiguityPolicy = "all"
+
+	// ambiguityRefuse synchronizes none of the matching accounts.
+	ambiguityRefuse ambiguityPolicy = "refuse"
+
+	// ambiguityPreferPreferredEmail synchronizes the only matching account
+	// whose preferred email is the Coder email, once normalized, and none if there is no such
+	// single account.
+	ambiguityPreferPreferredEmail ambiguityPolicy = "prefer-preferred-email"
+
+	// ambiguityPreferMostRecent synchronizes the most recently registered
+	// matching account.
+	ambiguityPreferMostRecent ambiguityPolicy = "prefer-most-recent"
+)
+
+// parseAmbiguityPolicy validates the value of the --ambiguous-match flag.
+func parseAmbiguityPolicy(s string) (ambiguityPolicy, error) {
+	switch p := ambiguityPolicy(s); p {
+	case ambiguityAll, ambiguityRefuse, ambiguityPreferPreferredEmail, ambiguityPreferMostRecent:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown ambiguous match policy %q", s)
+	}
+}
+
+// ambiguousMatch is a Coder user matching several Gerrit accounts.
+type ambiguousMatch struct {
+	user coderclient.CoderUser
+
+	// accountIDs lists the matching accounts.
+	accountIDs []int
+
+	// chosen lists the accounts synchronized, if any.
+	chosen []int
+}
+
+// ambiguityReport collects the ambiguous matches of a synchronization pass,
+// for Gerrit administrators to clean up duplicate accounts.
+type ambiguityReport struct {
+	mu      sync.Mutex
+	matches []ambiguousMatch
+}
+
+// record adds m to the report. A nil *ambiguityReport records nothing.
+func (r *ambiguityReport) record(m ambiguousMatch) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.matches = append(r.matches, m)
+}
+
+// log logs the recorded matches ordered by username.
+func (r *ambiguityReport) log() {
+	if r == nil || len(r.matches) == 0 {
+		return
+	}
+	slices.SortFunc(r.matches, func(a, b ambiguousMatch) int {
+		return cmp.Or(cmp.Compare(a.user.Username, b.user.Username), cmp.Compare(a.user.ID, b.user.ID))
+	})
+	log.Printf("%d Coder users matched several Gerrit accounts:", len(r.matches))
+	for _, m := range r.matches {
+		log.Printf("Ambiguous match: Coder user %q matched Gerrit users %v, synchronized %v", &m.user, m.accountIDs, m.chosen)
+	}
+}
+
+// resolveAmbiguity returns the accounts of gus, all matching user, that are
+// synchronized according to s.ambiguity, and records ambiguous matches in
+// s.ambiguous.
+func (s *syncer) resolveAmbiguity(ctx context.Context, user *coderclient.CoderUser, gus []gerrit.AccountInfo) ([]gerrit.AccountInfo, error) {
+	if len(gus) < 2 {
+		return gus, nil
+	}
+
+	var chosen []gerrit.AccountInfo
+	switch s.ambiguity {
+	case "", ambiguityAll:
+		chosen = gus
+	case ambiguityRefuse:
+	case ambiguityPreferPreferredEmail:
+		for _, gu := range gus {
+			if s.emails.equal(gu.Email, user.Email) {
+				chosen = append(chosen, gu)
+			}
+		}
+		if len(chosen) != 1 {
+			chosen = nil
+		}
+	case ambiguityPreferMostRecent:
+		gu, err := s.mostRecentAccount(ctx, gus)
+		if err != nil {
+			return nil, err
+		}
+		if gu != nil {
+			chosen = []gerrit.AccountInfo{*gu}
+		}
+	}
+
+	m := ambiguousMatch{user: *user}
+	for _, gu := range gus {
+		m.accountIDs = append(m.accountIDs, gu.AccountID)
+	}
+	for _, gu := range chosen {
+		m.chosen = append(m.chosen, gu.AccountID)
+	}
+	s.ambiguous.record(m)
+	log.Printf("Coder user %q matched Gerrit users %v, synchronizing %v by policy %s", user, m.accountIDs, m.chosen, cmp.Or(s.ambiguity, ambiguityAll))
+	return chosen, nil
+}
+
+// mostRecentAccount returns the most recently registered account of gus, or
+// nil if several were registered at the same time.
+func (s *syncer) mostRecentAccount(ctx context.Context, gus []gerrit.AccountInfo) (*gerrit.AccountInfo, error) {
+	var (
+		latest     *gerrit.AccountInfo
+		registered time.Time
+		tie        bool
+	)
+	for i, gu := range gus {
+		details, _, err := s.gerrit.GetAccountDetails(ctx, strconv.Itoa(gu.AccountID))
+		if err != nil {
+			return nil, fmt.Errorf("get details of Gerrit user %d: %w", gu.AccountID, err)
+		}
+		switch {
+		case latest == nil || details.RegisteredOn.After(registered):
+			latest, registered, tie = &gus[i], details.RegisteredOn.Time, false
+		case details.RegisteredOn.Equal(registered):
+			tie = true
+		}
+	}
+	if tie {
+		return nil, nil
+	}
+	return latest, nil
+}
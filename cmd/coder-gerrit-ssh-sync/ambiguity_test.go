@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+func TestParseAmbiguityPolicy(t *testing.T) {
+	testCases := []struct {
+		input     string
+		expected  ambiguityPolicy
+		expectErr bool
+	}{
+		{input: "all", expected: ambiguityAll},
+		{input: "refuse", expected: ambiguityRefuse},
+		{input: "prefer-preferred-email", expected: ambiguityPreferPreferredEmail},
+		{input: "prefer-most-recent", expected: ambiguityPreferMostRecent},
+		{input: "first", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseAmbiguityPolicy(tc.input)
+		if gotErr := err != nil; gotErr != tc.expectErr {
+			t.Errorf("parseAmbiguityPolicy(%q) error = %v, want error presence = %v", tc.input, err, tc.expectErr)
+		}
+		if got != tc.expected {
+			t.Errorf("parseAmbiguityPolicy(%q) = %q, want %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestResolveAmbiguity(t *testing.T) {
+	ctx := context.Background()
+	user := &coderclient.CoderUser{ID: "id-alice", Username: "alice", Email: "Alice@example.com"}
+	registered := func(day int) *gerrit.AccountDetailInfo {
+		return &gerrit.AccountDetailInfo{RegisteredOn: gerrit.Timestamp{Time: time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)}}
+	}
+
+	testCases := []struct {
+		name       string
+		policy     ambiguityPolicy
+		gus        []gerrit.AccountInfo
+		registered map[string]*gerrit.AccountDetailInfo
+		expected   []int
+		ambiguous  bool
+	}{
+		{
+			name:     "Single_match",
+			policy:   ambiguityRefuse,
+			gus:      []gerrit.AccountInfo{{AccountID: 1}},
+			expected: []int{1},
+		},
+		{
+			name:      "All",
+			policy:    ambiguityAll,
+			gus:       []gerrit.AccountInfo{{AccountID: 1}, {AccountID: 2}},
+			expected:  []int{1, 2},
+			ambiguous: true,
+		},
+		{
+			name:      "Refuse",
+			policy:    ambiguityRefuse,
+			gus:       []gerrit.AccountInfo{{AccountID: 1}, {AccountID: 2}},
+			ambiguous: true,
+		},
+		{
+			name:      "Preferred_email",
+			policy:    ambiguityPreferPreferredEmail,
+			gus:       []gerrit.AccountInfo{{AccountID: 1, Email: "old@example.com", SecondaryEmails: []string{"alice@example.com"}}, {AccountID: 2, Email: "alice@example.com"}},
+			expected:  []int{2},
+			ambiguous: true,
+		},
+		{
+			name:      "Preferred_email_not_unique",
+			policy:    ambiguityPreferPreferredEmail,
+			gus:       []gerrit.AccountInfo{{AccountID: 1, Email: "alice@example.com"}, {AccountID: 2, Email: "alice@example.com"}},
+			ambiguous: true,
+		},
+		{
+			name:       "Most_recent",
+			policy:     ambiguityPreferMostRecent,
+			gus:        []gerrit.AccountInfo{{AccountID: 1}, {AccountID: 2}, {AccountID: 3}},
+			registered: map[string]*gerrit.AccountDetailInfo{"1": registered(1), "2": registered(3), "3": registered(2)},
+			expected:   []int{2},
+			ambiguous:  true,
+		},
+		{
+			name:       "Most_recent_tie",
+			policy:     ambiguityPreferMostRecent,
+			gus:        []gerrit.AccountInfo{{AccountID: 1}, {AccountID: 2}},
+			registered: map[string]*gerrit.AccountDetailInfo{"1": registered(1), "2": registered(1)},
+			ambiguous:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &MockGerritClient{}
+			for id, details := range tc.registered {
+				g.On("GetAccountDetails", ctx, id).Return(details, &gerrit.Response{}, nil)
+			}
+			s := &syncer{gerrit: g, ambiguity: tc.policy, ambiguous: &ambiguityReport{}}
+
+			gus, err := s.resolveAmbiguity(ctx, user, tc.gus)
+			if err != nil {
+				t.Fatalf("resolveAmbiguity() error = %v", err)
+			}
+			var got []int
+			for _, gu := range gus {
+				got = append(got, gu.AccountID)
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("account IDs mismatch (-want +got):\n%s", diff)
+			}
+			if reported := len(s.ambiguous.matches) > 0; reported != tc.ambiguous {
+				t.Errorf("reported ambiguous match = %v, want %v", reported, tc.ambiguous)
+			}
+		})
+	}
+}
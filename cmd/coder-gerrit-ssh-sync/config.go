@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	flag "github.com/spf13/pflag"
+)
+
+// envPrefix prefixes the environment variables setting flags: --inactive-action
+// is set by CODER_GERRIT_SSH_SYNC_INACTIVE_ACTION.
+const envPrefix = "CODER_GERRIT_SSH_SYNC_"
+
+// configFlag names the flag of the configuration file, which the file itself
+// cannot set.
+const configFlag = "config"
+
+// envName returns the environment variable setting the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvAndConfig sets the flags of fs that were not given on the command
+// line, first from their environment variables, as returned by lookupEnv, and
+// then from the configuration file named by the --config flag, if any. Flags
+// given on the command line thus take precedence over environment variables,
+// which take precedence over the configuration file, which takes precedence
+// over defaults.
+//
+// The configuration file is a YAML mapping of flag names to values, lists
+// being given for flags that may be repeated. Unknown keys are rejected.
+func applyEnvAndConfig(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] {
+			return
+		}
+		v, ok := lookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+			return
+		}
+		given[f.Name] = true
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	path := fs.Lookup(configFlag).Value.String()
+	if path == "" {
+		return nil
+	}
+	values, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == configFlag || fs.Lookup(name) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown option %q", path, name))
+			continue
+		}
+		if given[name] {
+			continue
+		}
+		for _, v := range values[name] {
+			if err := fs.Set(name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", path, name, err))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadConfigFile reads the configuration file path, returning the values of
+// every option, one for scalars and one per element for lists.
+func loadConfigFile(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	values := map[string][]string{}
+	for name, node := range doc {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			values[name] = []string{node.Value}
+		case yaml.SequenceNode:
+			var list []string
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%s: %s: want a list of scalars", path, name)
+				}
+				list = append(list, item.Value)
+			}
+			if len(list) == 0 {
+				return nil, fmt.Errorf("%s: %s: empty list", path, name)
+			}
+			values[name] = list
+		default:
+			return nil, fmt.Errorf("%s: %s: want a scalar or a list", path, name)
+		}
+	}
+	return values, nil
+}
+
+// checkBaseURL reports whether s is not a usable base URL of a Coder or
+// Gerrit instance.
+func checkBaseURL(s string) error {
+	if s == "" {
+		return fmt.Errorf("must be set")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", s)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	flag "github.com/spf13/pflag"
+)
+
+func TestApplyEnvAndConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	full := writeConfig("full.yaml", `coder: https://coder.example.com
+gerrit: https://gerrit.example.com
+inactive-action: revoke
+dry-run: true
+interval: 10m
+match: [mapping, email]
+`)
+
+	type options struct {
+		Coder          string
+		Gerrit         string
+		InactiveAction string
+		DryRun         bool
+		Interval       time.Duration
+		Match          []string
+	}
+
+	testCases := []struct {
+		name      string
+		args      []string
+		env       map[string]string
+		expected  options
+		expectErr bool
+	}{
+		{
+			name:     "Defaults",
+			expected: options{InactiveAction: "skip", Match: []string{"email"}},
+		},
+		{
+			name: "Config_file",
+			args: []string{"--config", full},
+			expected: options{
+				Coder: "https://coder.example.com", Gerrit: "https://gerrit.example.com",
+				InactiveAction: "revoke", DryRun: true, Interval: 10 * time.Minute, Match: []string{"mapping", "email"},
+			},
+		},
+		{
+			name: "Precedence",
+			args: []string{"--config", full, "--inactive-action", "deactivate"},
+			env: map[string]string{
+				"CODER_GERRIT_SSH_SYNC_INACTIVE_ACTION": "skip",
+				"CODER_GERRIT_SSH_SYNC_GERRIT":          "https://gerrit.env.example.com",
+				"CODER_GERRIT_SSH_SYNC_MATCH":           "username,email",
+			},
+			expected: options{
+				Coder: "https://coder.example.com", Gerrit: "https://gerrit.env.example.com",
+				InactiveAction: "deactivate", DryRun: true, Interval: 10 * time.Minute, Match: []string{"username", "email"},
+			},
+		},
+		{
+			name: "Config_file_from_env",
+			env:  map[string]string{"CODER_GERRIT_SSH_SYNC_CONFIG": writeConfig("env.yml", "coder: https://coder.example.com\n")},
+			expected: options{
+				Coder: "https://coder.example.com", InactiveAction: "skip", Match: []string{"email"},
+			},
+		},
+		{
+			name:     "Empty_config_file",
+			args:     []string{"--config", writeConfig("empty.yaml", "")},
+			expected: options{InactiveAction: "skip", Match: []string{"email"}},
+		},
+		{
+			name:      "Unknown_key",
+			args:      []string{"--config", writeConfig("unknown.yaml", "coder-url: https://coder.example.com\n")},
+			expectErr: true,
+		},
+		{
+			name:      "Config_key",
+			args:      []string{"--config", writeConfig("nested.yaml", "config: other.yaml\n")},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid_value",
+			args:      []string{"--config", writeConfig("invalid.yaml", "dry-run: maybe\n")},
+			expectErr: true,
+		},
+		{
+			name:      "Mapping_value",
+			args:      []string{"--config", writeConfig("mapping.yaml", "match:\n  first: email\n")},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid_env",
+			env:       map[string]string{"CODER_GERRIT_SSH_SYNC_INTERVAL": "often"},
+			expectErr: true,
+		},
+		{
+			name:      "Missing_file",
+			args:      []string{"--config", filepath.Join(dir, "missing.yaml")},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.String(configFlag, "", "")
+			var got options
+			fs.StringVar(&got.Coder, "coder", "", "")
+			fs.StringVar(&got.Gerrit, "gerrit", "", "")
+			fs.StringVar(&got.InactiveAction, "inactive-action", "skip", "")
+			fs.BoolVar(&got.DryRun, "dry-run", false, "")
+			fs.DurationVar(&got.Interval, "interval", 0, "")
+			fs.StringSliceVar(&got.Match, "match", []string{"email"}, "")
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyEnvAndConfig(fs, func(name string) (string, bool) {
+				v, ok := tc.env[name]
+				return v, ok
+			})
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("applyEnvAndConfig() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("options mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckBaseURL(t *testing.T) {
+	testCases := []struct {
+		input     string
+		expectErr bool
+	}{
+		{input: "https://coder.example.com"},
+		{input: "http://localhost:8080/gerrit/"},
+		{input: "", expectErr: true},
+		{input: "coder.example.com", expectErr: true},
+		{input: "ftp://coder.example.com", expectErr: true},
+		{input: "https://", expectErr: true},
+		{input: "https://coder.example.com/%zz", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		if err := checkBaseURL(tc.input); (err != nil) != tc.expectErr {
+			t.Errorf("checkBaseURL(%q) error = %v, want error presence = %v", tc.input, err, tc.expectErr)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// nextDelay returns interval extended by a random fraction of up to jitter
+// of it, so that deployments started together drift apart over time.
+func nextDelay(interval time.Duration, jitter float64, r func() float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(float64(interval)*jitter*r())
+}
+
+// runDaemon calls pass repeatedly, waiting for interval plus jitter between
+// the end of a pass and the start of the next one, until stop is done. Passes
+// never overlap, and a pass in progress is not interrupted by stop; pass is
+// expected to check stop itself between units of work.
+func runDaemon(stop context.Context, interval time.Duration, jitter float64, pass func()) {
+	for {
+		pass()
+		if stop.Err() != nil {
+			return
+		}
+
+		delay := nextDelay(interval, jitter, rand.Float64)
+		log.Printf("Next synchronization in %v", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
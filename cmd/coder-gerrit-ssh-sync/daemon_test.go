@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNextDelay(t *testing.T) {
+	testCases := []struct {
+		name     string
+		interval time.Duration
+		jitter   float64
+		random   float64
+		expected time.Duration
+	}{
+		{
+			name:     "No_jitter",
+			interval: time.Hour,
+			jitter:   0,
+			random:   0.5,
+			expected: time.Hour,
+		},
+		{
+			name:     "Half_of_max_jitter",
+			interval: time.Hour,
+			jitter:   0.2,
+			random:   0.5,
+			expected: time.Hour + 6*time.Minute,
+		},
+		{
+			name:     "Negative_jitter_ignored",
+			interval: time.Minute,
+			jitter:   -1,
+			random:   0.9,
+			expected: time.Minute,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := nextDelay(tc.interval, tc.jitter, func() float64 { return tc.random })
+			if got != tc.expected {
+				t.Errorf("nextDelay() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRunDaemonStops(t *testing.T) {
+	stop, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	passes := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runDaemon(stop, time.Millisecond, 0, func() {
+			passes++
+			if passes == 3 {
+				cancel()
+			}
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("runDaemon did not return after stop was cancelled")
+	}
+	if passes != 3 {
+		t.Errorf("runDaemon ran %d passes, want 3", passes)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// doctorCommand is the subcommand checking the configuration instead of
+// synchronizing.
+const doctorCommand = "doctor"
+
+// tokenExpiryWarning is how long before its expiry the Coder token is reported.
+const tokenExpiryWarning = 7 * 24 * time.Hour
+
+// checkStatus is the outcome of a doctor check.
+type checkStatus string
+
+const (
+	checkPass checkStatus = "PASS"
+	checkWarn checkStatus = "WARN"
+	checkFail checkStatus = "FAIL"
+	checkSkip checkStatus = "SKIP"
+)
+
+// checkWarning is returned by checks that pass with a caveat.
+type checkWarning string
+
+func (w checkWarning) Error() string {
+	return string(w)
+}
+
+// doctorCheck is a check of the doctor command.
+type doctorCheck struct {
+	name string
+
+	// run returns a detail of the passed check, or why it failed. A
+	// checkWarning reports a caveat without failing.
+	run func(ctx context.Context) (string, error)
+
+	// hint tells how to fix a failure.
+	hint string
+
+	// required skips the following checks of the group on failure, as they
+	// depend on this one.
+	required bool
+}
+
+// runChecks runs groups of checks in order, printing a report to w, and
+// reports whether no check failed.
+func runChecks(ctx context.Context, w io.Writer, groups ...[]doctorCheck) bool {
+	ok := true
+	for _, checks := range groups {
+		skip := ""
+		for _, c := range checks {
+			if skip != "" {
+				fmt.Fprintf(w, "%s  %s: requires %s\n", checkSkip, c.name, skip)
+				continue
+			}
+			detail, err := c.run(ctx)
+			var warning checkWarning
+			switch {
+			case errors.As(err, &warning):
+				fmt.Fprintf(w, "%s  %s: %v\n", checkWarn, c.name, warning)
+				if c.hint != "" {
+					fmt.Fprintf(w, "      hint: %s\n", c.hint)
+				}
+			case err != nil:
+				ok = false
+				fmt.Fprintf(w, "%s  %s: %v\n", checkFail, c.name, err)
+				if c.hint != "" {
+					fmt.Fprintf(w, "      hint: %s\n", c.hint)
+				}
+				if c.required {
+					skip = c.name
+				}
+			case detail != "":
+				fmt.Fprintf(w, "%s  %s: %s\n", checkPass, c.name, detail)
+			default:
+				fmt.Fprintf(w, "%s  %s\n", checkPass, c.name)
+			}
+		}
+	}
+	return ok
+}
+
+// coderChecks returns the checks that c, authenticated with token, can read
+// every user and their Git SSH key, as the synchronization does.
+func coderChecks(c *coderclient.CoderClient, token string, members membershipFilter, now func() time.Time) []doctorCheck {
+	var me *coderclient.CoderUser
+	return []doctorCheck{
+		{
+			name: "Coder reachable",
+			run: func(ctx context.Context) (string, error) {
+				bi, err := c.BuildInfo(ctx)
+				if err != nil {
+					return "", err
+				}
+				return "version " + bi.Version, nil
+			},
+			hint:     "check --coder and the --coder-ca-file, --coder-proxy and other --coder-* connection flags",
+			required: true,
+		},
+		{
+			name: "Coder token",
+			run: func(ctx context.Context) (string, error) {
+				var err error
+				if me, err = c.GetUser(ctx, "me"); err != nil {
+					return "", err
+				}
+				return "authenticated as " + me.Username, nil
+			},
+			hint:     "set a valid token with CODER_SESSION_TOKEN, --coder-token-file or --coder-credential-helper, such as one created with \"coder tokens create\"",
+			required: true,
+		},
+		{
+			name: "Coder token expiry",
+			run: func(ctx context.Context) (string, error) {
+				id := coderclient.APIKeyID(token)
+				if id == "" {
+					return "", checkWarning("the token is not a Coder API key, its expiry is unknown")
+				}
+				key, err := c.GetAPIKey(ctx, "me", id)
+				if err != nil {
+					return "", err
+				}
+				left := key.ExpiresAt.Sub(now())
+				switch {
+				case left <= 0:
+					return "", fmt.Errorf("expired at %s", key.ExpiresAt.Format(time.RFC3339))
+				case left < tokenExpiryWarning:
+					return "", checkWarning(fmt.Sprintf("expires at %s, in %s", key.ExpiresAt.Format(time.RFC3339), left.Round(time.Minute)))
+				}
+				return "expires at " + key.ExpiresAt.Format(time.RFC3339), nil
+			},
+			hint: "create a new token with \"coder tokens create --lifetime <duration>\", or rotate it through --coder-token-file or --coder-credential-helper",
+		},
+		{
+			name: "Coder user administration",
+			run: func(ctx context.Context) (string, error) {
+				for _, role := range []string{coderclient.RoleOwner, coderclient.RoleUserAdmin} {
+					if me.HasRole(role) {
+						return "site-wide role " + role, nil
+					}
+				}
+				return "", fmt.Errorf("%s has neither the %s nor the %s site-wide role", me.Username, coderclient.RoleOwner, coderclient.RoleUserAdmin)
+			},
+			hint: fmt.Sprintf("grant the %s or %s role to the Coder user of the token, with \"coder users edit-roles\"", coderclient.RoleOwner, coderclient.RoleUserAdmin),
+		},
+		{
+			name: "Coder Git SSH keys",
+			run: func(ctx context.Context) (string, error) {
+				page, err := c.ListUsersPage(ctx, &coderclient.ListUsersOptions{Limit: 2})
+				if err != nil {
+					return "", fmt.Errorf("list users: %w", err)
+				}
+				for _, u := range page.Users {
+					if u.ID == me.ID {
+						continue
+					}
+					if _, err := c.GetGitSSHKey(ctx, u.ID); err != nil {
+						return "", fmt.Errorf("read the Git SSH key of %s: %w", u.Username, err)
+					}
+					return "read the Git SSH key of " + u.Username, nil
+				}
+				return "", checkWarning("no other user to read the Git SSH key of")
+			},
+			hint: fmt.Sprintf("grant the %s or %s role to the Coder user of the token, and do not restrict the scope of the token", coderclient.RoleOwner, coderclient.RoleUserAdmin),
+		},
+		{
+			name: "Coder groups and organizations",
+			run: func(ctx context.Context) (string, error) {
+				if members.empty() {
+					return "not filtered by membership", nil
+				}
+				if _, err := members.resolve(ctx, c); err != nil {
+					return "", err
+				}
+				return "", nil
+			},
+			hint: "check the names given with --include-group, --exclude-group, --include-organization and --exclude-organization",
+		},
+	}
+}
+
+// gerritChecks returns the checks that the identity of g can find accounts
+// and manage their SSH keys, as the synchronization does.
+func gerritChecks(g *gerrit.Client, mapping *userMapping) []doctorCheck {
+	var self *gerrit.AccountInfo
+	return []doctorCheck{
+		{
+			name: "Gerrit reachable",
+			run: func(ctx context.Context) (string, error) {
+				v, _, err := g.Config.GetVersion(ctx)
+				if err != nil {
+					return "", err
+				}
+				return "version " + v, nil
+			},
+			hint:     "check --gerrit and the --gerrit-ca-file, --gerrit-proxy and other --gerrit-* connection flags",
+			required: true,
+		},
+		{
+			name: "Gerrit identity",
+			run: func(ctx context.Context) (string, error) {
+				var err error
+				if self, err = checkGerritIdentity(ctx, g.Accounts); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("authenticated as %s (%d)", self.Username, self.AccountID), nil
+			},
+			hint:     "check --gerrit-auth and its credentials: --gerrit-username with the HTTP password of the account, the bearer token or the .gitcookies file",
+			required: true,
+		},
+		{
+			name: "Gerrit viewAllAccounts capability",
+			run: func(ctx context.Context) (string, error) {
+				caps, _, err := g.Accounts.ListAccountCapabilities(ctx, "self", &gerrit.CapabilityOptions{
+					Filter: []string{"viewAllAccounts", "administrateServer"},
+				})
+				if err != nil {
+					return "", err
+				}
+				if !caps.ViewAllAccounts && !caps.AdministrateServer {
+					return "", fmt.Errorf("not granted to %s", self.Username)
+				}
+				return "", nil
+			},
+			hint: "grant the View All Accounts global capability to a group of the account in the access rights of All-Projects",
+		},
+		{
+			name: "Gerrit modifyAccount capability",
+			run: func(ctx context.Context) (string, error) {
+				_, resp, err := g.Accounts.CheckAccountCapability(ctx, "self", "modifyAccount")
+				if resp != nil && resp.Response != nil && resp.StatusCode == http.StatusNotFound {
+					return "", fmt.Errorf("not granted to %s", self.Username)
+				}
+				return "", err
+			},
+			hint: "grant the Modify Account global capability to a group of the account in the access rights of All-Projects",
+		},
+		{
+			name: "Gerrit mapped accounts",
+			run: func(ctx context.Context) (string, error) {
+				if mapping == nil {
+					return "no --mapping-file", nil
+				}
+				return "", mapping.resolve(ctx, g.Accounts)
+			},
+			hint: "fix the Gerrit account IDs of --mapping-file",
+		},
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// checkOutcomes returns the status and the name of every check reported in
+// report, the output of runChecks.
+func checkOutcomes(report string) []string {
+	var outcomes []string
+	for _, line := range strings.Split(report, "\n") {
+		status, rest, ok := strings.Cut(line, "  ")
+		if !ok || strings.HasPrefix(line, " ") {
+			continue
+		}
+		name, _, _ := strings.Cut(rest, ":")
+		outcomes = append(outcomes, status+" "+name)
+	}
+	return outcomes
+}
+
+func TestRunChecks(t *testing.T) {
+	ctx := context.Background()
+	pass := func(ctx context.Context) (string, error) { return "fine", nil }
+	warn := func(ctx context.Context) (string, error) { return "", checkWarning("careful") }
+	fail := func(ctx context.Context) (string, error) { return "", errors.New("broken") }
+
+	var b strings.Builder
+	ok := runChecks(ctx, &b,
+		[]doctorCheck{
+			{name: "first", run: pass, required: true},
+			{name: "second", run: fail, hint: "fix it", required: true},
+			{name: "third", run: pass},
+		},
+		[]doctorCheck{
+			{name: "fourth", run: warn, hint: "look at it"},
+			{name: "fifth", run: fail},
+			{name: "sixth", run: pass},
+		},
+	)
+	if ok {
+		t.Errorf("runChecks() = true despite failures, want false")
+	}
+
+	expected := "PASS  first: fine\n" +
+		"FAIL  second: broken\n" +
+		"      hint: fix it\n" +
+		"SKIP  third: requires second\n" +
+		"WARN  fourth: careful\n" +
+		"      hint: look at it\n" +
+		"FAIL  fifth: broken\n" +
+		"PASS  sixth: fine\n"
+	if diff := cmp.Diff(expected, b.String()); diff != "" {
+		t.Errorf("runChecks() report mismatch (-want +got):\n%s", diff)
+	}
+
+	if !runChecks(ctx, &strings.Builder{}, []doctorCheck{{name: "warned", run: warn}}) {
+		t.Errorf("runChecks() = false with warnings only, want true")
+	}
+}
+
+func TestCoderChecks(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		token      string
+		roles      string
+		expiresAt  time.Time
+		keyStatus  int
+		members    membershipFilter
+		expectedOK bool
+		expected   []string
+	}{
+		{
+			name:       "Owner",
+			token:      "abcdefghij-secret",
+			roles:      `[{"name": "owner"}]`,
+			expiresAt:  now.Add(30 * 24 * time.Hour),
+			expectedOK: true,
+			expected: []string{
+				"PASS Coder reachable", "PASS Coder token", "PASS Coder token expiry",
+				"PASS Coder user administration", "PASS Coder Git SSH keys", "PASS Coder groups and organizations",
+			},
+		},
+		{
+			name:       "Expiring_opaque_token",
+			token:      "opaque",
+			roles:      `[{"name": "user-admin"}]`,
+			expectedOK: true,
+			expected: []string{
+				"PASS Coder reachable", "PASS Coder token", "WARN Coder token expiry",
+				"PASS Coder user administration", "PASS Coder Git SSH keys", "PASS Coder groups and organizations",
+			},
+		},
+		{
+			name:      "Expiring_soon",
+			token:     "abcdefghij-secret",
+			roles:     `[{"name": "owner"}]`,
+			expiresAt: now.Add(time.Hour),
+			members:   membershipFilter{includeGroups: []groupRef{{name: "developers"}}},
+			expected: []string{
+				"PASS Coder reachable", "PASS Coder token", "WARN Coder token expiry",
+				"PASS Coder user administration", "PASS Coder Git SSH keys", "FAIL Coder groups and organizations",
+			},
+		},
+		{
+			name:      "Member",
+			token:     "abcdefghij-secret",
+			roles:     `[{"name": "owner", "organization_id": "o1"}]`,
+			expiresAt: now.Add(-time.Hour),
+			keyStatus: http.StatusForbidden,
+			expected: []string{
+				"PASS Coder reachable", "PASS Coder token", "FAIL Coder token expiry",
+				"FAIL Coder user administration", "FAIL Coder Git SSH keys", "PASS Coder groups and organizations",
+			},
+		},
+		{
+			name:  "Invalid_token",
+			token: "invalid",
+			expected: []string{
+				"PASS Coder reachable", "FAIL Coder token", "SKIP Coder token expiry",
+				"SKIP Coder user administration", "SKIP Coder Git SSH keys", "SKIP Coder groups and organizations",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v2/buildinfo":
+					fmt.Fprintln(w, `{"version": "v2.15.0"}`)
+					return
+				}
+				if r.Header.Get("Coder-Session-Token") == "invalid" {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprintln(w, `{"message": "Invalid session token."}`)
+					return
+				}
+				switch r.URL.Path {
+				case "/api/v2/users/me":
+					fmt.Fprintf(w, `{"id": "id-bot", "username": "bot", "roles": %s}`, tc.roles)
+				case "/api/v2/users/me/keys/abcdefghij":
+					fmt.Fprintf(w, `{"id": "abcdefghij", "expires_at": %q}`, tc.expiresAt.Format(time.RFC3339))
+				case "/api/v2/users":
+					fmt.Fprintln(w, `{"users": [{"id": "id-bot", "username": "bot"}, {"id": "id-alice", "username": "alice"}], "count": 2}`)
+				case "/api/v2/users/id-alice/gitsshkey":
+					if tc.keyStatus != 0 {
+						w.WriteHeader(tc.keyStatus)
+						return
+					}
+					fmt.Fprintln(w, `{"user_id": "id-alice", "public_key": "ssh-ed25519 AAAA"}`)
+				case "/api/v2/groups":
+					fmt.Fprintln(w, `[]`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			c := coderclient.NewCoderClient(server.URL, tc.token)
+			var b strings.Builder
+			ok := runChecks(ctx, &b, coderChecks(c, tc.token, tc.members, func() time.Time { return now }))
+			if ok != tc.expectedOK {
+				t.Errorf("runChecks() = %v, want %v; report:\n%s", ok, tc.expectedOK, b.String())
+			}
+			if diff := cmp.Diff(tc.expected, checkOutcomes(b.String())); diff != "" {
+				t.Errorf("check outcomes mismatch (-want +got):\n%s\nreport:\n%s", diff, b.String())
+			}
+		})
+	}
+}
+
+func TestGerritChecks(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name         string
+		account      string
+		capabilities string
+		modify       bool
+		mapping      *userMapping
+		expectedOK   bool
+		expected     []string
+	}{
+		{
+			name:         "Administrator",
+			account:      `{"_account_id": 1000, "username": "sync-bot"}`,
+			capabilities: `{"administrateServer": true}`,
+			modify:       true,
+			mapping:      &userMapping{Users: []userMappingEntry{{Coder: "alice", Gerrit: []int{1001}}}},
+			expectedOK:   true,
+			expected: []string{
+				"PASS Gerrit reachable", "PASS Gerrit identity", "PASS Gerrit viewAllAccounts capability",
+				"PASS Gerrit modifyAccount capability", "PASS Gerrit mapped accounts",
+			},
+		},
+		{
+			name:         "Missing_capabilities",
+			account:      `{"_account_id": 1000, "username": "sync-bot"}`,
+			capabilities: `{}`,
+			mapping:      &userMapping{Users: []userMappingEntry{{Coder: "alice", Gerrit: []int{1002}}}},
+			expected: []string{
+				"PASS Gerrit reachable", "PASS Gerrit identity", "FAIL Gerrit viewAllAccounts capability",
+				"FAIL Gerrit modifyAccount capability", "FAIL Gerrit mapped accounts",
+			},
+		},
+		{
+			name:    "Anonymous",
+			account: `{}`,
+			expected: []string{
+				"PASS Gerrit reachable", "FAIL Gerrit identity", "SKIP Gerrit viewAllAccounts capability",
+				"SKIP Gerrit modifyAccount capability", "SKIP Gerrit mapped accounts",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/a/config/server/version":
+					fmt.Fprintln(w, `)]}'`)
+					fmt.Fprintln(w, `"3.9.1"`)
+				case "/a/accounts/self":
+					fmt.Fprintln(w, `)]}'`)
+					fmt.Fprintln(w, tc.account)
+				case "/a/accounts/self/capabilities":
+					if got := r.URL.Query()["q"]; !cmp.Equal(got, []string{"viewAllAccounts", "administrateServer"}) {
+						t.Errorf("capabilities filter = %q, want viewAllAccounts and administrateServer", got)
+					}
+					fmt.Fprintln(w, `)]}'`)
+					fmt.Fprintln(w, tc.capabilities)
+				case "/a/accounts/self/capabilities/modifyAccount":
+					if !tc.modify {
+						http.NotFound(w, r)
+						return
+					}
+					fmt.Fprintln(w, `)]}'`)
+					fmt.Fprintln(w, `"ok"`)
+				case "/a/accounts/1001":
+					fmt.Fprintln(w, `)]}'`)
+					fmt.Fprintln(w, `{"_account_id": 1001}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			auth, err := newGerritAuth(gerritAuthBasic, server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, err := newGerritClient(ctx, server.URL, auth, "sync-bot", "password", &http.Client{})
+			if err != nil {
+				t.Fatalf("newGerritClient() error = %v", err)
+			}
+			var b strings.Builder
+			ok := runChecks(ctx, &b, gerritChecks(g, tc.mapping))
+			if ok != tc.expectedOK {
+				t.Errorf("runChecks() = %v, want %v; report:\n%s", ok, tc.expectedOK, b.String())
+			}
+			if diff := cmp.Diff(tc.expected, checkOutcomes(b.String())); diff != "" {
+				t.Errorf("check outcomes mismatch (-want +got):\n%s\nreport:\n%s", diff, b.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// emailDomainFilter selects the Coder users synchronized by the domain of
+// their email. The zero value selects every user.
+type emailDomainFilter struct {
+	// allow, if not empty, lists the only domains whose users are
+	// synchronized.
+	allow []string
+
+	// deny lists domains whose users are never synchronized.
+	deny []string
+}
+
+// parseEmailDomainFilter validates the values of the --allow-email-domain and
+// --deny-email-domain flags.
+func parseEmailDomainFilter(allow, deny []string) (emailDomainFilter, error) {
+	var f emailDomainFilter
+	for _, d := range allow {
+		d = strings.ToLower(d)
+		if d == "" || strings.Contains(d, "@") {
+			return emailDomainFilter{}, fmt.Errorf("invalid email domain %q", d)
+		}
+		f.allow = append(f.allow, d)
+	}
+	for _, d := range deny {
+		d = strings.ToLower(d)
+		if d == "" || strings.Contains(d, "@") {
+			return emailDomainFilter{}, fmt.Errorf("invalid email domain %q", d)
+		}
+		if slices.Contains(f.allow, d) {
+			return emailDomainFilter{}, fmt.Errorf("email domain %q is both allowed and denied", d)
+		}
+		f.deny = append(f.deny, d)
+	}
+	return f, nil
+}
+
+// reject returns why the Coder user with email is not synchronized, or "" if
+// it is. The domain of email matches a listed domain either as it is or as the
+// canonical domain emails replaces it with, so that denying an alias domain or
+// its canonical domain both deny the alias domain.
+func (f emailDomainFilter) reject(email string, emails emailNormalizer) string {
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return ""
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "no email domain"
+	}
+	domains := []string{email[at+1:]}
+	if canonical, ok := emails.domainAliases[domains[0]]; ok {
+		domains = append(domains, canonical)
+	}
+	listed := func(list []string) bool {
+		return slices.ContainsFunc(domains, func(d string) bool { return slices.Contains(list, d) })
+	}
+	if listed(f.deny) {
+		return "email domain denied"
+	}
+	if len(f.allow) > 0 && !listed(f.allow) {
+		return "email domain not allowed"
+	}
+	return ""
+}
+
+// skipCounts counts the Coder users skipped during a pass by reason. It is
+// safe for concurrent use, and a nil *skipCounts counts nothing.
+type skipCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// add counts a user skipped for reason.
+func (c *skipCounts) add(reason string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = map[string]int{}
+	}
+	c.counts[reason]++
+}
+
+// log logs the counts, the most frequent reasons first.
+func (c *skipCounts) log() {
+	if c == nil || len(c.counts) == 0 {
+		return
+	}
+	reasons := slices.SortedFunc(maps.Keys(c.counts), func(a, b string) int {
+		return cmp.Or(cmp.Compare(c.counts[b], c.counts[a]), cmp.Compare(a, b))
+	})
+	total := 0
+	for _, n := range c.counts {
+		total += n
+	}
+	log.Printf("Skipped %d users", total)
+	for _, reason := range reasons {
+		log.Printf("Skipped for %s: %d", reason, c.counts[reason])
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseEmailDomainFilter(t *testing.T) {
+	testCases := []struct {
+		name      string
+		allow     []string
+		deny      []string
+		expected  emailDomainFilter
+		expectErr bool
+	}{
+		{name: "None"},
+		{
+			name:     "Lowercased",
+			allow:    []string{"Example.com"},
+			deny:     []string{"Contractors.Example.com"},
+			expected: emailDomainFilter{allow: []string{"example.com"}, deny: []string{"contractors.example.com"}},
+		},
+		{name: "Empty", allow: []string{""}, expectErr: true},
+		{name: "Email", deny: []string{"alice@example.com"}, expectErr: true},
+		{name: "Allowed_and_denied", allow: []string{"example.com"}, deny: []string{"EXAMPLE.com"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseEmailDomainFilter(tc.allow, tc.deny)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("parseEmailDomainFilter() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expected, got, cmp.AllowUnexported(emailDomainFilter{})); diff != "" {
+				t.Errorf("parseEmailDomainFilter() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEmailDomainFilterReject(t *testing.T) {
+	emails := emailNormalizer{domainAliases: map[string]string{"corp.example.com": "example.com"}}
+
+	testCases := []struct {
+		name     string
+		filter   emailDomainFilter
+		email    string
+		expected string
+	}{
+		{name: "No_filter", email: "", expected: ""},
+		{name: "Allowed", filter: emailDomainFilter{allow: []string{"example.com"}}, email: "alice@Example.com", expected: ""},
+		{name: "Allowed_alias", filter: emailDomainFilter{allow: []string{"example.com"}}, email: "alice@corp.example.com", expected: ""},
+		{name: "Not_allowed", filter: emailDomainFilter{allow: []string{"example.com"}}, email: "bob@contractor.example", expected: "email domain not allowed"},
+		{name: "Denied", filter: emailDomainFilter{deny: []string{"contractor.example"}}, email: "bob@contractor.example", expected: "email domain denied"},
+		{name: "Not_denied", filter: emailDomainFilter{deny: []string{"contractor.example"}}, email: "alice@example.com", expected: ""},
+		{name: "No_email", filter: emailDomainFilter{deny: []string{"contractor.example"}}, email: "", expected: "no email domain"},
+		{name: "Denied_alias", filter: emailDomainFilter{deny: []string{"corp.example.com"}}, email: "alice@corp.example.com", expected: "email domain denied"},
+		{name: "Denied_canonical", filter: emailDomainFilter{deny: []string{"example.com"}}, email: "alice@corp.example.com", expected: "email domain denied"},
+		{name: "Canonical_not_denied_by_alias", filter: emailDomainFilter{deny: []string{"corp.example.com"}}, email: "alice@example.com", expected: ""},
+		{name: "Allowed_alias_only", filter: emailDomainFilter{allow: []string{"corp.example.com"}}, email: "alice@corp.example.com", expected: ""},
+		{name: "Alias_denied_canonical_allowed", filter: emailDomainFilter{allow: []string{"example.com"}, deny: []string{"corp.example.com"}}, email: "alice@corp.example.com", expected: "email domain denied"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.reject(tc.email, emails); got != tc.expected {
+				t.Errorf("reject(%q) = %q, want %q", tc.email, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestReconcileDomainFilter(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("after_id") != "" {
+			fmt.Fprintln(w, `{"users": [], "count": 0}`)
+			return
+		}
+		fmt.Fprintln(w, `{"users": [`+
+			`{"id": "id-1", "username": "bob", "email": "bob@contractor.example", "status": "active"},`+
+			`{"id": "id-2", "username": "carol", "email": "carol@contractor.example", "status": "active"},`+
+			`{"id": "id-3", "username": "dave", "email": "dave@partner.example", "status": "active"}`+
+			`], "count": 3}`)
+	}))
+	defer server.Close()
+
+	g := &MockGerritClient{QueryErr: errors.New("must not query Gerrit")}
+	s := &syncer{coder: coderclient.NewCoderClient(server.URL, "test-token"), gerrit: g}
+	config := &config{
+		concurrency: 1,
+		domains:     emailDomainFilter{allow: []string{"example.com"}, deny: []string{"partner.example"}},
+	}
+	if err := reconcile(ctx, ctx, config, s); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+
+	if len(g.Queries) != 0 {
+		t.Errorf("queried Gerrit %q for filtered users", g.Queries)
+	}
+	expected := map[string]int{"email domain not allowed": 2, "email domain denied": 1}
+	if diff := cmp.Diff(expected, s.skipped.counts); diff != "" {
+		t.Errorf("skip counts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReconcileDomainFilterRevoke(t *testing.T) {
+	ctx := context.Background()
+	managedKey := generateTestSSHKey(t) + " " + managedKeyCommentPrefix + "id-1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("after_id") != "" {
+			fmt.Fprintln(w, `{"users": [], "count": 0}`)
+			return
+		}
+		fmt.Fprintln(w, `{"users": [{"id": "id-1", "username": "bob", "email": "bob@partner.example", "status": "active"}], "count": 1}`)
+	}))
+	defer server.Close()
+
+	g := &MockGerritClient{
+		QueryResult:       []gerrit.AccountInfo{{AccountID: 123}},
+		ListSSHKeysResult: []gerrit.SSHKeyInfo{{Seq: 1, SSHPublicKey: managedKey}},
+	}
+	g.On("DeleteSSHKey", ctx, "123", "1").Return(mockResponse(http.StatusNoContent), nil).Once()
+	s := &syncer{coder: coderclient.NewCoderClient(server.URL, "test-token"), gerrit: g, inactiveAction: inactiveActionRevoke}
+	config := &config{
+		concurrency: 1,
+		domains:     emailDomainFilter{deny: []string{"partner.example"}},
+	}
+	if err := reconcile(ctx, ctx, config, s); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+
+	g.AssertExpectations(t)
+	g.AssertNotCalled(t, "AddSSHKey", mock.Anything, mock.Anything, mock.Anything)
+}
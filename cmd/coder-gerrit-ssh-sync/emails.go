@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// emailNormalizer brings email addresses into the form in which Coder and
+// Gerrit addresses are compared. The zero value only folds case.
+type emailNormalizer struct {
+	// stripPlusTags removes the "+tag" suffix of local parts, so that
+	// "alice+ci@example.com" compares equal to "alice@example.com".
+	stripPlusTags bool
+
+	// domainAliases maps lowercased alias domains to the canonical domain
+	// they are replaced with.
+	domainAliases map[string]string
+}
+
+// parseDomainAliases validates the values of the --email-domain-alias flag,
+// each of the form "alias=canonical".
+func parseDomainAliases(values []string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, v := range values {
+		alias, canonical, ok := strings.Cut(strings.ToLower(v), "=")
+		if !ok || alias == "" || canonical == "" || strings.Contains(alias, "@") || strings.Contains(canonical, "@") {
+			return nil, fmt.Errorf("invalid domain alias %q, want alias=canonical", v)
+		}
+		if alias == canonical {
+			return nil, fmt.Errorf("domain %q is an alias of itself", alias)
+		}
+		if _, ok := aliases[alias]; ok {
+			return nil, fmt.Errorf("domain alias %q given more than once", alias)
+		}
+		aliases[alias] = canonical
+	}
+	for alias, canonical := range aliases {
+		if _, ok := aliases[canonical]; ok {
+			return nil, fmt.Errorf("canonical domain %q of %q is itself an alias", canonical, alias)
+		}
+	}
+	return aliases, nil
+}
+
+// normalize returns the normalized form of email.
+func (n emailNormalizer) normalize(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+	if n.stripPlusTags {
+		local, _, _ = strings.Cut(local, "+")
+	}
+	if canonical, ok := n.domainAliases[domain]; ok {
+		domain = canonical
+	}
+	return local + "@" + domain
+}
+
+// equal reports whether the emails a and b are the same once normalized.
+func (n emailNormalizer) equal(a, b string) bool {
+	return a != "" && b != "" && n.normalize(a) == n.normalize(b)
+}
+
+// variants returns the addresses under which Gerrit may have registered
+// email: email as given, its normalized form and the normalized form in every
+// alias domain. Addresses with other plus tags cannot be enumerated.
+func (n emailNormalizer) variants(email string) []string {
+	if email == "" {
+		return nil
+	}
+	normalized := n.normalize(email)
+	variants := []string{email}
+	add := func(v string) {
+		for _, existing := range variants {
+			if existing == v {
+				return
+			}
+		}
+		variants = append(variants, v)
+	}
+	add(strings.ToLower(email))
+	add(normalized)
+
+	at := strings.LastIndex(normalized, "@")
+	if at < 0 {
+		return variants
+	}
+	local, domain := normalized[:at], normalized[at+1:]
+	for alias, canonical := range n.domainAliases {
+		if canonical == domain {
+			add(local + "@" + alias)
+		}
+	}
+	return variants
+}
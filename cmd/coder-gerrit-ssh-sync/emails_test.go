@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+func TestParseDomainAliases(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     []string
+		expected  map[string]string
+		expectErr bool
+	}{
+		{name: "None", input: nil, expected: map[string]string{}},
+		{
+			name:     "Aliases",
+			input:    []string{"Corp.Example.com=example.com", "example.org=example.com"},
+			expected: map[string]string{"corp.example.com": "example.com", "example.org": "example.com"},
+		},
+		{name: "Missing_canonical", input: []string{"example.org="}, expectErr: true},
+		{name: "No_separator", input: []string{"example.org"}, expectErr: true},
+		{name: "Email", input: []string{"alice@example.org=example.com"}, expectErr: true},
+		{name: "Self", input: []string{"example.com=example.com"}, expectErr: true},
+		{name: "Duplicate", input: []string{"example.org=example.com", "example.org=example.net"}, expectErr: true},
+		{name: "Chain", input: []string{"example.org=example.com", "example.com=example.net"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseDomainAliases(tc.input)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("parseDomainAliases() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("parseDomainAliases() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEmailNormalizer(t *testing.T) {
+	n := emailNormalizer{stripPlusTags: true, domainAliases: map[string]string{"corp.example.com": "example.com"}}
+
+	testCases := []struct {
+		email    string
+		expected string
+	}{
+		{email: "Alice@Example.com", expected: "alice@example.com"},
+		{email: "alice+ci@example.com", expected: "alice@example.com"},
+		{email: "alice+ci@corp.example.com", expected: "alice@example.com"},
+		{email: "alice@example.org", expected: "alice@example.org"},
+		{email: "alice", expected: "alice"},
+	}
+	for _, tc := range testCases {
+		if got := n.normalize(tc.email); got != tc.expected {
+			t.Errorf("normalize(%q) = %q, want %q", tc.email, got, tc.expected)
+		}
+	}
+
+	if got := (emailNormalizer{}).normalize("Alice+CI@Example.com"); got != "alice+ci@example.com" {
+		t.Errorf("zero normalizer normalize() = %q, want only case folded", got)
+	}
+	if n.equal("", "") {
+		t.Errorf("equal() of empty emails = true, want false")
+	}
+
+	expected := []string{"Alice+ci@Example.com", "alice+ci@example.com", "alice@example.com", "alice@corp.example.com"}
+	if diff := cmp.Diff(expected, n.variants("Alice+ci@Example.com")); diff != "" {
+		t.Errorf("variants() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEmailMatcher(t *testing.T) {
+	ctx := context.Background()
+	user := &coderclient.CoderUser{ID: "id-alice", Username: "alice", Email: "alice+coder@corp.example.com"}
+	emails := emailNormalizer{stripPlusTags: true, domainAliases: map[string]string{"corp.example.com": "example.com"}}
+
+	t.Run("Query", func(t *testing.T) {
+		g := &queryGerrit{
+			MockGerritClient: &MockGerritClient{},
+			results: map[string][]gerrit.AccountInfo{
+				`email:"alice@example.com"`:      {{AccountID: 1}},
+				`email:"alice@corp.example.com"`: {{AccountID: 1}, {AccountID: 2}},
+			},
+		}
+		m := &emailMatcher{src: matchSources{gerrit: g, emails: emails}}
+
+		gus, err := m.match(ctx, user)
+		if err != nil {
+			t.Fatalf("match() error = %v", err)
+		}
+		if diff := cmp.Diff([]gerrit.AccountInfo{{AccountID: 1}, {AccountID: 2}}, gus); diff != "" {
+			t.Errorf("match() mismatch (-want +got):\n%s", diff)
+		}
+		expectedQueries := []string{`email:"alice+coder@corp.example.com"`, `email:"alice@example.com"`, `email:"alice@corp.example.com"`}
+		if diff := cmp.Diff(expectedQueries, g.queries); diff != "" {
+			t.Errorf("queries mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Index", func(t *testing.T) {
+		idx := newGerritAccountIndex(emails)
+		idx.add(gerrit.AccountInfo{AccountID: 1, Email: "Alice+gerrit@example.com"})
+		idx.add(gerrit.AccountInfo{AccountID: 2, Email: "bob@example.com"})
+		m := &emailMatcher{src: matchSources{gerrit: &MockGerritClient{}, accounts: idx, emails: emails}}
+
+		gus, err := m.match(ctx, user)
+		if err != nil {
+			t.Fatalf("match() error = %v", err)
+		}
+		if diff := cmp.Diff([]gerrit.AccountInfo{{AccountID: 1, Email: "Alice+gerrit@example.com"}}, gus); diff != "" {
+			t.Errorf("match() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Verified", func(t *testing.T) {
+		g := &queryGerrit{
+			MockGerritClient: &MockGerritClient{},
+			results: map[string][]gerrit.AccountInfo{
+				`email:"alice@example.com"`: {{AccountID: 1}, {AccountID: 2}, {AccountID: 3}},
+			},
+		}
+		g.On("ListAccountEmails", ctx, "1").Return(&[]gerrit.EmailInfo{{Email: "alice@example.com", PendingConfirmation: true}}, &gerrit.Response{}, nil)
+		g.On("ListAccountEmails", ctx, "2").Return(&[]gerrit.EmailInfo{{Email: "old@example.com", Preferred: true}, {Email: "alice@corp.example.com"}}, &gerrit.Response{}, nil)
+		g.On("ListAccountEmails", ctx, "3").Return(&[]gerrit.EmailInfo{{Email: "bob@example.com"}}, &gerrit.Response{}, nil)
+		m := &emailMatcher{src: matchSources{gerrit: g, emails: emails, verifiedEmails: true}}
+
+		gus, err := m.match(ctx, user)
+		if err != nil {
+			t.Fatalf("match() error = %v", err)
+		}
+		if diff := cmp.Diff([]gerrit.AccountInfo{{AccountID: 2}}, gus); diff != "" {
+			t.Errorf("match() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
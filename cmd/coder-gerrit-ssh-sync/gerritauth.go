@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/andygrunwald/go-gerrit"
+)
+
+// gerritAuthMode is the way requests to Gerrit are authenticated.
+type gerritAuthMode string
+
+const (
+	// gerritAuthBasic sends the username and the HTTP password with HTTP
+	// basic authentication.
+	gerritAuthBasic gerritAuthMode = "basic"
+
+	// gerritAuthDigest sends the username and the HTTP password with HTTP
+	// digest authentication.
+	gerritAuthDigest gerritAuthMode = "digest"
+
+	// gerritAuthCookie sends the cookie of the Gerrit host found in a
+	// .gitcookies file.
+	gerritAuthCookie gerritAuthMode = "cookie"
+
+	// gerritAuthBearer sends the password as bearer token in the
+	// Authorization header, as expected by some authenticating proxies.
+	gerritAuthBearer gerritAuthMode = "bearer"
+)
+
+// parseGerritAuthMode validates the value of the --gerrit-auth flag.
+func parseGerritAuthMode(s string) (gerritAuthMode, error) {
+	switch m := gerritAuthMode(s); m {
+	case gerritAuthBasic, gerritAuthDigest, gerritAuthCookie, gerritAuthBearer:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown Gerrit authentication mode %q", s)
+	}
+}
+
+// gerritAuth authenticates the requests of a Gerrit client according to mode.
+type gerritAuth struct {
+	mode gerritAuthMode
+
+	// host is the Gerrit host, whose cookie is used with gerritAuthCookie.
+	host string
+
+	// mu guards token.
+	mu sync.RWMutex
+
+	// token is the bearer token sent with gerritAuthBearer.
+	token string
+}
+
+// newGerritAuth returns the authentication of requests to the Gerrit instance
+// at gerritURL with mode.
+func newGerritAuth(mode gerritAuthMode, gerritURL string) (*gerritAuth, error) {
+	u, err := url.Parse(gerritURL)
+	if err != nil {
+		return nil, err
+	}
+	return &gerritAuth{mode: mode, host: strings.ToLower(u.Hostname())}, nil
+}
+
+// baseURL returns the base URL of the Gerrit client for gerritURL. Without
+// credentials it knows of, go-gerrit sends anonymous requests, so bearer
+// requests are sent to the authenticated /a/ endpoints explicitly.
+func (a *gerritAuth) baseURL(gerritURL string) string {
+	if a.mode != gerritAuthBearer {
+		return gerritURL
+	}
+	return strings.TrimSuffix(gerritURL, "/") + "/a/"
+}
+
+// wrap returns base sending the bearer token with gerritAuthBearer.
+func (a *gerritAuth) wrap(base http.RoundTripper) http.RoundTripper {
+	if a.mode != gerritAuthBearer {
+		return base
+	}
+	return &bearerTransport{auth: a, base: base}
+}
+
+// set makes auth authenticate requests with username and secret: the HTTP
+// password, the bearer token or the content of the .gitcookies file,
+// depending on a.mode. Basic and digest authentication require both a
+// username and a password, which a credential helper may fail to return.
+func (a *gerritAuth) set(auth *gerrit.AuthenticationService, username, secret string) error {
+	if (a.mode == gerritAuthBasic || a.mode == gerritAuthDigest) && (username == "" || secret == "") {
+		return fmt.Errorf("--gerrit-auth=%s requires a Gerrit username and HTTP password", a.mode)
+	}
+	switch a.mode {
+	case gerritAuthDigest:
+		auth.SetDigestAuth(username, secret)
+	case gerritAuthCookie:
+		name, value, err := findGitCookie(secret, a.host)
+		if err != nil {
+			return err
+		}
+		auth.SetCookieAuth(name, value)
+	case gerritAuthBearer:
+		a.mu.Lock()
+		a.token = secret
+		a.mu.Unlock()
+	default:
+		auth.SetBasicAuth(username, secret)
+	}
+	return nil
+}
+
+// bearerTransport sends the bearer token of auth with every request.
+type bearerTransport struct {
+	auth *gerritAuth
+	base http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.auth.mu.RLock()
+	token := t.auth.token
+	t.auth.mu.RUnlock()
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// findGitCookie returns the name and the value of the cookie for host in
+// gitcookies, the content of a .gitcookies file in the Netscape cookie file
+// format. The cookie of the most specific domain wins.
+func findGitCookie(gitcookies, host string) (name, value string, err error) {
+	best := -1
+	sc := bufio.NewScanner(strings.NewReader(gitcookies))
+	for sc.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(sc.Text()), "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+		domain := strings.ToLower(fields[0])
+		subdomains := fields[1] == "TRUE" || strings.HasPrefix(domain, ".")
+		domain = strings.TrimPrefix(domain, ".")
+		if host != domain && !(subdomains && strings.HasSuffix(host, "."+domain)) {
+			continue
+		}
+		if len(domain) > best {
+			best, name, value = len(domain), fields[5], fields[6]
+		}
+	}
+	if best < 0 {
+		return "", "", fmt.Errorf("no cookie for %s in .gitcookies file", host)
+	}
+	return name, value, nil
+}
+
+// checkGerritIdentity returns the account Gerrit authenticates requests of svc
+// as, failing for anonymous requests, which cannot manage SSH keys of other
+// accounts.
+func checkGerritIdentity(ctx context.Context, svc gerritAccountsService) (*gerrit.AccountInfo, error) {
+	self, _, err := svc.GetAccount(ctx, "self")
+	if err != nil {
+		return nil, err
+	}
+	if self == nil || self.AccountID <= 0 {
+		return nil, fmt.Errorf("requests are anonymous")
+	}
+	return self, nil
+}
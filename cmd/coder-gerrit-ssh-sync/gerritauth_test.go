@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andygrunwald/go-gerrit"
+)
+
+func TestParseGerritAuthMode(t *testing.T) {
+	testCases := []struct {
+		input     string
+		expected  gerritAuthMode
+		expectErr bool
+	}{
+		{input: "basic", expected: gerritAuthBasic},
+		{input: "digest", expected: gerritAuthDigest},
+		{input: "cookie", expected: gerritAuthCookie},
+		{input: "bearer", expected: gerritAuthBearer},
+		{input: "oauth", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseGerritAuthMode(tc.input)
+		if gotErr := err != nil; gotErr != tc.expectErr {
+			t.Errorf("parseGerritAuthMode(%q) error = %v, want error presence = %v", tc.input, err, tc.expectErr)
+		}
+		if got != tc.expected {
+			t.Errorf("parseGerritAuthMode(%q) = %q, want %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestFindGitCookie(t *testing.T) {
+	gitcookies := "# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tTRUE\t2147483647\to\tgit-wildcard=1\n" +
+		"#HttpOnly_gerrit.example.com\tFALSE\t/\tTRUE\t2147483647\to\tgit-gerrit=2\n" +
+		"other.example.org\tFALSE\t/\tTRUE\t2147483647\to\tgit-other=3\n" +
+		"malformed line\n"
+
+	testCases := []struct {
+		host          string
+		expectedValue string
+		expectErr     bool
+	}{
+		{host: "gerrit.example.com", expectedValue: "git-gerrit=2"},
+		{host: "review.example.com", expectedValue: "git-wildcard=1"},
+		{host: "example.com", expectedValue: "git-wildcard=1"},
+		{host: "sub.other.example.org", expectErr: true},
+		{host: "gerrit.example.net", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		name, value, err := findGitCookie(gitcookies, tc.host)
+		if gotErr := err != nil; gotErr != tc.expectErr {
+			t.Errorf("findGitCookie(%q) error = %v, want error presence = %v", tc.host, err, tc.expectErr)
+			continue
+		}
+		if err == nil && (name != "o" || value != tc.expectedValue) {
+			t.Errorf("findGitCookie(%q) = %q, %q, want \"o\", %q", tc.host, name, value, tc.expectedValue)
+		}
+	}
+}
+
+func TestNewGerritClientAuth(t *testing.T) {
+	ctx := context.Background()
+	var got http.Header
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, path = r.Header, r.URL.Path
+		fmt.Fprintln(w, `)]}'`)
+		fmt.Fprintln(w, `{"_account_id": 1000, "username": "sync-bot"}`)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		mode   gerritAuthMode
+		secret string
+		check  func(h http.Header) bool
+	}{
+		{mode: gerritAuthBasic, secret: "password", check: func(h http.Header) bool {
+			return h.Get("Authorization") == "Basic c3luYy1ib3Q6cGFzc3dvcmQ="
+		}},
+		{mode: gerritAuthCookie, secret: "127.0.0.1\tFALSE\t/\tFALSE\t0\to\tgit-bot=secret\n", check: func(h http.Header) bool {
+			return h.Get("Cookie") == "o=git-bot=secret"
+		}},
+		{mode: gerritAuthBearer, secret: "token", check: func(h http.Header) bool {
+			return h.Get("Authorization") == "Bearer token"
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			auth, err := newGerritAuth(tc.mode, server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := newGerritClient(ctx, server.URL, auth, "sync-bot", tc.secret, &http.Client{})
+			if err != nil {
+				t.Fatalf("newGerritClient() error = %v", err)
+			}
+			self, err := checkGerritIdentity(ctx, client.Accounts)
+			if err != nil {
+				t.Fatalf("checkGerritIdentity() error = %v", err)
+			}
+			if self.AccountID != 1000 {
+				t.Errorf("checkGerritIdentity() = %d, want 1000", self.AccountID)
+			}
+			if path != "/a/accounts/self" {
+				t.Errorf("requested %q, want the authenticated /a/accounts/self", path)
+			}
+			if !tc.check(got) {
+				t.Errorf("request headers %v miss the credentials", got)
+			}
+		})
+	}
+}
+
+func TestGerritAuthSetMissingCredentials(t *testing.T) {
+	testCases := []struct {
+		mode      gerritAuthMode
+		username  string
+		secret    string
+		expectErr bool
+	}{
+		{mode: gerritAuthBasic, username: "sync-bot", secret: "password"},
+		{mode: gerritAuthBasic, secret: "password", expectErr: true},
+		{mode: gerritAuthBasic, username: "sync-bot", expectErr: true},
+		{mode: gerritAuthDigest, secret: "password", expectErr: true},
+		{mode: gerritAuthBearer, secret: "token"},
+	}
+
+	for _, tc := range testCases {
+		auth, err := newGerritAuth(tc.mode, "https://gerrit.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := gerrit.NewClient(context.Background(), "https://gerrit.example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = auth.set(client.Authentication, tc.username, tc.secret)
+		if gotErr := err != nil; gotErr != tc.expectErr {
+			t.Errorf("set(%s, %q, %q) error = %v, want error presence = %v", tc.mode, tc.username, tc.secret, err, tc.expectErr)
+		}
+	}
+}
+
+func TestCheckGerritIdentity(t *testing.T) {
+	ctx := context.Background()
+
+	g := &MockGerritClient{}
+	g.On("GetAccount", ctx, "self").Return(&gerrit.AccountInfo{}, &gerrit.Response{}, nil).Once()
+	if _, err := checkGerritIdentity(ctx, g); err == nil {
+		t.Errorf("checkGerritIdentity() of an anonymous identity succeeded, want error")
+	}
+
+	g.On("GetAccount", ctx, "self").Return((*gerrit.AccountInfo)(nil), (*gerrit.Response)(nil), errors.New("403 Forbidden")).Once()
+	if _, err := checkGerritIdentity(ctx, g); err == nil {
+		t.Errorf("checkGerritIdentity() succeeded despite error, want error")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// groupRef names a Coder group, optionally within an organization.
+type groupRef struct {
+	// organization is the name or ID of the organization of the group, or ""
+	// for groups of that name in every organization.
+	organization string
+
+	name string
+}
+
+func (g groupRef) String() string {
+	if g.organization == "" {
+		return g.name
+	}
+	return g.organization + "/" + g.name
+}
+
+// parseGroupRefs validates group names of the form "group" or
+// "organization/group".
+func parseGroupRefs(values []string) ([]groupRef, error) {
+	var refs []groupRef
+	for _, v := range values {
+		var g groupRef
+		if org, name, ok := strings.Cut(v, "/"); ok {
+			g = groupRef{organization: org, name: name}
+			if org == "" {
+				return nil, fmt.Errorf("invalid group %q, want group or organization/group", v)
+			}
+		} else {
+			g = groupRef{name: v}
+		}
+		if g.name == "" || strings.Contains(g.name, "/") {
+			return nil, fmt.Errorf("invalid group %q, want group or organization/group", v)
+		}
+		refs = append(refs, g)
+	}
+	return refs, nil
+}
+
+// membershipFilter selects the Coder users synchronized by their membership
+// in Coder groups and organizations. The zero value selects every user.
+type membershipFilter struct {
+	// includeGroups and includeOrganizations, if any is not empty, list the
+	// only groups and organizations whose members are synchronized.
+	includeGroups        []groupRef
+	includeOrganizations []string
+
+	// excludeGroups and excludeOrganizations list groups and organizations
+	// whose members are never synchronized.
+	excludeGroups        []groupRef
+	excludeOrganizations []string
+}
+
+// parseMembershipFilter validates the values of the --include-group,
+// --exclude-group, --include-organization and --exclude-organization flags.
+func parseMembershipFilter(includeGroups, excludeGroups, includeOrgs, excludeOrgs []string) (membershipFilter, error) {
+	include, err := parseGroupRefs(includeGroups)
+	if err != nil {
+		return membershipFilter{}, err
+	}
+	exclude, err := parseGroupRefs(excludeGroups)
+	if err != nil {
+		return membershipFilter{}, err
+	}
+	for _, org := range append(append([]string{}, includeOrgs...), excludeOrgs...) {
+		if org == "" {
+			return membershipFilter{}, fmt.Errorf("empty organization")
+		}
+	}
+	return membershipFilter{
+		includeGroups:        include,
+		includeOrganizations: includeOrgs,
+		excludeGroups:        exclude,
+		excludeOrganizations: excludeOrgs,
+	}, nil
+}
+
+// empty reports whether f selects every user without looking up groups.
+func (f membershipFilter) empty() bool {
+	return len(f.includeGroups) == 0 && len(f.includeOrganizations) == 0 &&
+		len(f.excludeGroups) == 0 && len(f.excludeOrganizations) == 0
+}
+
+// resolve lists the members of the groups and organizations of f from c. Every
+// group and organization must exist, so that a misspelled name does not
+// silently select nobody, or everybody.
+func (f membershipFilter) resolve(ctx context.Context, c *coderclient.CoderClient) (*memberships, error) {
+	if f.empty() {
+		return nil, nil
+	}
+	groups, err := c.ListGroups(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("list Coder groups: %w", err)
+	}
+	var orgs []coderclient.CoderOrganization
+	if len(f.includeOrganizations) > 0 || len(f.excludeOrganizations) > 0 {
+		if orgs, err = c.ListOrganizations(ctx); err != nil {
+			return nil, fmt.Errorf("list Coder organizations: %w", err)
+		}
+	}
+
+	m := &memberships{excluded: map[string]bool{}}
+	if len(f.includeGroups) > 0 || len(f.includeOrganizations) > 0 {
+		m.included = map[string]bool{}
+	}
+	for _, r := range []struct {
+		groups []groupRef
+		orgs   []string
+		users  map[string]bool
+	}{
+		{groups: f.includeGroups, orgs: f.includeOrganizations, users: m.included},
+		{groups: f.excludeGroups, orgs: f.excludeOrganizations, users: m.excluded},
+	} {
+		for _, ref := range r.groups {
+			found := false
+			for _, g := range groups {
+				if g.Name == ref.name && (ref.organization == "" || ref.organization == g.OrganizationName || ref.organization == g.OrganizationID) {
+					found = true
+					addMembers(r.users, g)
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("no Coder group %q", ref)
+			}
+		}
+		for _, name := range r.orgs {
+			g, err := everyoneGroup(name, orgs, groups)
+			if err != nil {
+				return nil, err
+			}
+			addMembers(r.users, g)
+		}
+	}
+	log.Printf("Resolved Coder group membership: %d users included, %d excluded", len(m.included), len(m.excluded))
+	return m, nil
+}
+
+// everyoneGroup returns the group of every member of the organization with
+// the name or ID org, which Coder gives the ID of the organization.
+func everyoneGroup(org string, orgs []coderclient.CoderOrganization, groups []coderclient.CoderGroup) (coderclient.CoderGroup, error) {
+	for _, o := range orgs {
+		if o.Name != org && o.ID != org {
+			continue
+		}
+		for _, g := range groups {
+			if g.ID == o.ID {
+				return g, nil
+			}
+		}
+		return coderclient.CoderGroup{}, fmt.Errorf("no group of every member of Coder organization %q", org)
+	}
+	return coderclient.CoderGroup{}, fmt.Errorf("no Coder organization %q", org)
+}
+
+// addMembers adds the IDs of the members of g to users.
+func addMembers(users map[string]bool, g coderclient.CoderGroup) {
+	if len(g.Members) < g.TotalMemberCount {
+		log.Printf("Warning: Coder listed %d of the %d members of group %q", len(g.Members), g.TotalMemberCount, g.Name)
+	}
+	for _, u := range g.Members {
+		users[u.ID] = true
+	}
+}
+
+// memberships holds the Coder users selected by a membershipFilter, resolved
+// once per pass.
+type memberships struct {
+	// included holds the IDs of the users of the included groups and
+	// organizations, or is nil if every user is included.
+	included map[string]bool
+
+	// excluded holds the IDs of the users of the excluded groups and
+	// organizations.
+	excluded map[string]bool
+}
+
+// reject returns why user is not synchronized, or "" if it is. A nil
+// *memberships rejects nobody.
+func (m *memberships) reject(user *coderclient.CoderUser) string {
+	switch {
+	case m == nil:
+		return ""
+	case m.excluded[user.ID]:
+		return "member of excluded Coder group"
+	case m.included != nil && !m.included[user.ID]:
+		return "not member of included Coder group"
+	default:
+		return ""
+	}
+}
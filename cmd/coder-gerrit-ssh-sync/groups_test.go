@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+func TestParseMembershipFilter(t *testing.T) {
+	testCases := []struct {
+		name          string
+		includeGroups []string
+		excludeGroups []string
+		includeOrgs   []string
+		expected      membershipFilter
+		expectErr     bool
+	}{
+		{name: "None"},
+		{
+			name:          "Groups",
+			includeGroups: []string{"gerrit-contributors", "eng/reviewers"},
+			excludeGroups: []string{"contractors"},
+			includeOrgs:   []string{"eng"},
+			expected: membershipFilter{
+				includeGroups:        []groupRef{{name: "gerrit-contributors"}, {organization: "eng", name: "reviewers"}},
+				excludeGroups:        []groupRef{{name: "contractors"}},
+				includeOrganizations: []string{"eng"},
+			},
+		},
+		{name: "Empty_group", includeGroups: []string{""}, expectErr: true},
+		{name: "Empty_organization", excludeGroups: []string{"/contractors"}, expectErr: true},
+		{name: "Nested", includeGroups: []string{"eng/team/reviewers"}, expectErr: true},
+		{name: "Empty_organization_flag", includeOrgs: []string{""}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseMembershipFilter(tc.includeGroups, tc.excludeGroups, tc.includeOrgs, nil)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("parseMembershipFilter() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expected, got, cmp.AllowUnexported(membershipFilter{}, groupRef{})); diff != "" {
+				t.Errorf("parseMembershipFilter() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMembershipFilterResolve(t *testing.T) {
+	ctx := context.Background()
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/api/v2/groups":
+			fmt.Fprintln(w, `[
+				{"id": "org-eng", "name": "Everyone", "organization_id": "org-eng", "organization_name": "eng", "members": [{"id": "id-alice"}, {"id": "id-bob"}, {"id": "id-carol"}]},
+				{"id": "g-1", "name": "gerrit-contributors", "organization_id": "org-eng", "organization_name": "eng", "members": [{"id": "id-alice"}, {"id": "id-bob"}]},
+				{"id": "g-2", "name": "gerrit-contributors", "organization_id": "org-ops", "organization_name": "ops", "members": [{"id": "id-dave"}]},
+				{"id": "g-3", "name": "contractors", "organization_id": "org-eng", "organization_name": "eng", "members": [{"id": "id-bob"}]}
+			]`)
+		case "/api/v2/organizations":
+			fmt.Fprintln(w, `[{"id": "org-eng", "name": "eng"}, {"id": "org-ops", "name": "ops"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	c := coderclient.NewCoderClient(server.URL, "test-token")
+
+	testCases := []struct {
+		name      string
+		filter    membershipFilter
+		expected  map[string]string
+		expectErr bool
+	}{
+		{
+			name:     "Include_group_in_every_organization",
+			filter:   membershipFilter{includeGroups: []groupRef{{name: "gerrit-contributors"}}},
+			expected: map[string]string{"id-alice": "", "id-bob": "", "id-carol": "not member of included Coder group", "id-dave": ""},
+		},
+		{
+			name:     "Include_group_in_organization",
+			filter:   membershipFilter{includeGroups: []groupRef{{organization: "ops", name: "gerrit-contributors"}}},
+			expected: map[string]string{"id-alice": "not member of included Coder group", "id-bob": "not member of included Coder group", "id-carol": "not member of included Coder group", "id-dave": ""},
+		},
+		{
+			name:     "Include_organization_exclude_group",
+			filter:   membershipFilter{includeOrganizations: []string{"eng"}, excludeGroups: []groupRef{{name: "contractors"}}},
+			expected: map[string]string{"id-alice": "", "id-bob": "member of excluded Coder group", "id-carol": "", "id-dave": "not member of included Coder group"},
+		},
+		{
+			name:      "Unknown_group",
+			filter:    membershipFilter{excludeGroups: []groupRef{{name: "contractor"}}},
+			expectErr: true,
+		},
+		{
+			name:      "Unknown_organization",
+			filter:    membershipFilter{includeOrganizations: []string{"sales"}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := tc.filter.resolve(ctx, c)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("resolve() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			got := map[string]string{}
+			for id := range tc.expected {
+				got[id] = m.reject(&coderclient.CoderUser{ID: id})
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("reject() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	requests = 0
+	m, err := membershipFilter{}.resolve(ctx, c)
+	if err != nil || m != nil || requests != 0 {
+		t.Errorf("empty filter resolve() = %v, %v after %d requests, want nil without request", m, err, requests)
+	}
+	if reason := m.reject(&coderclient.CoderUser{ID: "id-alice"}); reason != "" {
+		t.Errorf("nil memberships reject() = %q, want \"\"", reason)
+	}
+}
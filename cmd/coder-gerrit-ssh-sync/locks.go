@@ -0,0 +1,31 @@
+package main
+
+import "sync"
+
+// accountLocks serializes work on the same Gerrit account across workers, so
+// that two Coder users matching one account never modify it concurrently.
+type accountLocks struct {
+	mu    sync.Mutex
+	locks map[int]*sync.Mutex
+}
+
+// lock locks the Gerrit account accountID and returns the function unlocking
+// it. A nil *accountLocks does not lock anything.
+func (l *accountLocks) lock(accountID int) func() {
+	if l == nil {
+		return func() {}
+	}
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[int]*sync.Mutex{}
+	}
+	m, ok := l.locks[accountID]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[accountID] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
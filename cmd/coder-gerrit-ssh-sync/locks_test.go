@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAccountLocks(t *testing.T) {
+	var l accountLocks
+
+	unlock := l.lock(123)
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		l.lock(123)()
+	}()
+
+	// A different account is not blocked by account 123.
+	l.lock(456)()
+
+	select {
+	case <-locked:
+		t.Fatal("account 123 was locked twice")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(10 * time.Second):
+		t.Fatal("account 123 was not unlocked")
+	}
+}
+
+func TestAccountLocksConcurrent(t *testing.T) {
+	var l accountLocks
+	var wg sync.WaitGroup
+	counter := 0
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer l.lock(1)()
+			counter++
+		}()
+	}
+	wg.Wait()
+	if counter != 50 {
+		t.Errorf("counter = %d, want 50", counter)
+	}
+}
+
+func TestAccountLocksNil(t *testing.T) {
+	var l *accountLocks
+	l.lock(1)()
+}
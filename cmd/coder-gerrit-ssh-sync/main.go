@@ -18,19 +18,27 @@ limitations under the License.
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"github.com/andygrunwald/go-gerrit"
 	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/ratelimit"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/retry"
 	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/version"
 	flag "github.com/spf13/pflag"
 )
@@ -46,30 +54,314 @@ type gerritAccountsService interface {
 
 	// ListSSHKey to corresponding Gerrit accounts.
 	ListSSHKeys(ctx context.Context, accountID string) (*[]gerrit.SSHKeyInfo, *gerrit.Response, error)
+
+	// DeleteSSHKey removes a stale SSH key from corresponding Gerrit accounts.
+	DeleteSSHKey(ctx context.Context, accountID, sshKeyID string) (*gerrit.Response, error)
+
+	// DeleteActive deactivates Gerrit accounts of non-active Coder users.
+	DeleteActive(ctx context.Context, accountID string) (*gerrit.Response, error)
+
+	// SetActive reactivates Gerrit accounts of Coder users that became active again.
+	SetActive(ctx context.Context, accountID string) (*gerrit.Response, error)
+
+	// GetAccount returns explicitly mapped Gerrit accounts.
+	GetAccount(ctx context.Context, account string) (*gerrit.AccountInfo, *gerrit.Response, error)
+
+	// GetAccountExternalIDs lists the external IDs used to match Gerrit accounts.
+	GetAccountExternalIDs(ctx context.Context, accountID string) (*[]gerrit.AccountExternalIdInfo, *gerrit.Response, error)
+
+	// GetAccountDetails returns the registration date of ambiguously matched Gerrit accounts.
+	GetAccountDetails(ctx context.Context, accountID string) (*gerrit.AccountDetailInfo, *gerrit.Response, error)
+
+	// ListAccountEmails lists the emails of Gerrit accounts to check that they are verified.
+	ListAccountEmails(ctx context.Context, accountID string) (*[]gerrit.EmailInfo, *gerrit.Response, error)
+}
+
+// inactiveAction controls how Gerrit accounts of suspended or dormant Coder
+// users are handled.
+type inactiveAction string
+
+const (
+	// inactiveActionSkip leaves Gerrit accounts of non-active Coder users untouched.
+	inactiveActionSkip inactiveAction = "skip"
+
+	// inactiveActionRevoke removes Coder-managed SSH keys of non-active Coder users.
+	inactiveActionRevoke inactiveAction = "revoke"
+
+	// inactiveActionDeactivate removes Coder-managed SSH keys and deactivates the
+	// Gerrit accounts of non-active Coder users. The accounts are only
+	// reactivated once the Coder user is active again with
+	// --reactivate-accounts.
+	inactiveActionDeactivate inactiveAction = "deactivate"
+)
+
+// parseInactiveAction validates the value of the --inactive-action flag.
+func parseInactiveAction(s string) (inactiveAction, error) {
+	switch a := inactiveAction(s); a {
+	case inactiveActionSkip, inactiveActionRevoke, inactiveActionDeactivate:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown inactive action %q", s)
+	}
+}
+
+// managedKeyCommentPrefix is written into the comment of every SSH key added
+// by this tool, followed by the Coder user ID. Only keys carrying the exact
+// comment of a Coder user are ever removed on their behalf.
+const managedKeyCommentPrefix = "coder-gerrit-ssh-sync:"
+
+// managedKeyComment returns the SSH key comment that marks keys owned by user.
+func managedKeyComment(user *coderclient.CoderUser) string {
+	return managedKeyCommentPrefix + user.ID
 }
 
 type config struct {
 	coderURL       string
-	token          string
 	gerritInstance string
-	gerritUsername string
-	gerritPassword string
-	filterOnly     string
+	credentials    *credentials
+	gerritAuth     *gerritAuth
+	doctor         bool
+	coderHTTP      *httpOptions
+	gerritHTTP     *httpOptions
+	selection      userSelection
+	coderQuery     string
+	inactiveAction inactiveAction
+	reactivate     bool
+	dryRun         bool
+	output         outputFormat
+	interval       time.Duration
+	jitter         float64
+	retryPolicy    retry.Policy
+	concurrency    int
+	coderQPS       float64
+	gerritQPS      float64
+
+	prefetchAccounts bool
+	gerritPageSize   int
+
+	matchStrategies []matchStrategy
+	oidc            oidcIdentity
+	mapping         *userMapping
+	ambiguity       ambiguityPolicy
+
+	emails         emailNormalizer
+	verifiedEmails bool
+	domains        emailDomainFilter
+	members        membershipFilter
+	policy         userPolicy
+	minLastSeen    time.Duration
 }
 
-// parseFlags parses command line flags and environment variables to configure the application.
+// parseFlags parses command line flags, environment variables and the
+// configuration file to configure the application.
 func parseFlags() *config {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [%s]\n\nSynchronize the Git SSH keys of Coder users to their Gerrit accounts, or with %s, check the connectivity and permissions of the credentials without changing anything.\n\nFlags:\n", os.Args[0], doctorCommand, doctorCommand)
+		flag.PrintDefaults()
+	}
+	flag.String(configFlag, "", "YAML file setting any option by flag name, such as \"inactive-action: revoke\", with lists for options that may be repeated. Every option is also set by its environment variable, such as CODER_GERRIT_SSH_SYNC_INACTIVE_ACTION. Command line flags take precedence over environment variables, which take precedence over the file")
 	coderURL := flag.String("coder", "", "Base URL for Coder instance")
-	token := os.Getenv("CODER_SESSION_TOKEN")
+	tokenFile := flag.String("coder-token-file", "", "File holding the Coder session token, instead of CODER_SESSION_TOKEN; read again before every synchronization")
+	tokenHelper := flag.String("coder-credential-helper", "", "git-credential style command returning the Coder session token as password, instead of CODER_SESSION_TOKEN; run with the argument get before every synchronization")
 	gerritInstance := flag.String("gerrit", "", "Base URL for Gerrit instance")
-	gerritUsername := os.Getenv("GERRIT_USERNAME")
-	gerritPassword := os.Getenv("GERRIT_PASSWORD")
-	filterOnly := flag.String("only", "", "Work on this specific user only for testing")
+	gerritUsername := flag.String("gerrit-username", "", "Gerrit username of the HTTP password; GERRIT_USERNAME is used if empty")
+	gerritPasswordFile := flag.String("gerrit-password-file", "", "File holding the Gerrit HTTP password, instead of GERRIT_PASSWORD; read again before every synchronization")
+	gerritAuthMode := flag.String("gerrit-auth", string(gerritAuthBasic), "How requests to Gerrit are authenticated: basic or digest (--gerrit-username and the HTTP password), cookie (the cookie of the Gerrit host in --gerrit-gitcookies-file) or bearer (the password sent as bearer token)")
+	gitCookiesFile := flag.String("gerrit-gitcookies-file", "", "The .gitcookies file holding the Gerrit cookie with --gerrit-auth=cookie; read again before every synchronization")
+	gerritHelper := flag.String("gerrit-credential-helper", "", "git-credential style command returning the Gerrit username and HTTP password, instead of --gerrit-username and GERRIT_PASSWORD; run with the argument get before every synchronization")
+	only := flag.StringSlice("only", nil, "Only synchronize the Coder users with these emails; may be repeated. Shorthand for --select=email:<email>")
+	selectors := flag.StringArray("select", nil, "Only synchronize the Coder users matching any of these selectors: email:<email>, username:<username>, id:<user ID>, glob:<pattern> or regex:<expression>, the patterns matching usernames and emails; may be repeated")
+	coderQuery := flag.String("coder-query", "", "Coder user search query, such as \"status:active\", limiting the users listed from Coder")
+	inactive := flag.String("inactive-action", string(inactiveActionSkip), "What to do with Gerrit accounts of suspended or dormant Coder users: skip, revoke (remove Coder-managed SSH keys) or deactivate (also deactivate the accounts). Unless skip, Coder-managed SSH keys of users filtered out by role, login type, email domain, group or organization are removed too, without deactivating their accounts")
+	reactivate := flag.Bool("reactivate-accounts", false, "With --inactive-action=deactivate, reactivate inactive Gerrit accounts of active Coder users. Gerrit does not record who deactivated an account, so this also reactivates accounts deactivated by Gerrit administrators")
+
+	dryRun := flag.Bool("dry-run", false, "Only print the changes that would be made to Gerrit accounts, without making them")
+	output := flag.String("output", string(outputFormatTable), "Format of the changes printed in dry-run mode: table or json")
+
+	interval := flag.Duration("interval", 0, "Keep running and repeat the synchronization at this interval; run once and exit if zero")
+	jitter := flag.Float64("jitter", 0.1, "Extend each --interval by a random fraction of up to this much of it")
+
+	retryPolicy := retry.DefaultPolicy()
+	flag.IntVar(&retryPolicy.MaxAttempts, "retry-max-attempts", retryPolicy.MaxAttempts, "Maximum number of attempts of a failed Coder or Gerrit request, including the first one")
+	flag.DurationVar(&retryPolicy.BaseDelay, "retry-base-delay", retryPolicy.BaseDelay, "Delay before the first retry of a failed request, doubling with every further retry")
+	flag.DurationVar(&retryPolicy.MaxDelay, "retry-max-delay", retryPolicy.MaxDelay, "Maximum delay between retries, including delays requested with Retry-After")
+
+	concurrency := flag.Int("concurrency", 1, "Number of Coder users synchronized concurrently")
+	coderQPS := flag.Float64("coder-qps", 0, "Maximum number of requests per second sent to Coder; unlimited if zero")
+	gerritQPS := flag.Float64("gerrit-qps", 0, "Maximum number of requests per second sent to Gerrit; unlimited if zero")
+	coderHTTP := registerHTTPFlags(flag.CommandLine, "coder", "Coder")
+	gerritHTTP := registerHTTPFlags(flag.CommandLine, "gerrit", "Gerrit")
+
+	prefetchAccounts := flag.Bool("prefetch-gerrit-accounts", false, "Load all Gerrit accounts once per synchronization and match users against them, instead of querying Gerrit for every user")
+	gerritPageSize := flag.Int("gerrit-page-size", defaultAccountsPageSize, "Number of Gerrit accounts requested per page with --prefetch-gerrit-accounts")
+
+	match := flag.StringSlice("match", []string{string(matchEmail)}, "Ordered strategies to find the Gerrit accounts of a Coder user, the first finding any wins: email, username, external-id (OIDC subject in Gerrit external IDs; without --prefetch-gerrit-accounts, it only confirms accounts with the Coder email or username) or mapping, which must come first when --mapping-file maps users")
+	oidcSubjectField := flag.String("oidc-subject-field", string(oidcFieldUsername), "Coder user field holding the OIDC subject of users logging in with OIDC, as configured with Coder's --oidc-username-field or --oidc-email-field: username or email")
+	oidcSchemes := flag.StringSlice("oidc-external-id-schemes", []string{"oauth", "openid"}, "Schemes of the Gerrit external IDs holding OIDC subjects, used by the external-id match strategy")
+	ambiguous := flag.String("ambiguous-match", string(ambiguityAll), "Which Gerrit accounts to synchronize when a Coder user matches several: all, refuse (none), prefer-preferred-email (the only one whose preferred email is the Coder email) or prefer-most-recent (the most recently registered)")
+	mappingFile := flag.String("mapping-file", "", "YAML (.yaml, .yml) or JSON file explicitly mapping Coder users to Gerrit accounts, used by the mapping match strategy, which must then come first in --match, and excluding Coder users from synchronization")
+
+	stripPlusTags := flag.Bool("email-strip-plus-tags", false, "Ignore the +tag suffix of the local part when comparing Coder and Gerrit emails. Without --prefetch-gerrit-accounts, Gerrit emails with a tag are not found")
+	domainAliases := flag.StringSlice("email-domain-alias", nil, "Treat emails in the alias domain as emails in the canonical domain, given as alias=canonical; may be repeated")
+	verifiedEmails := flag.Bool("require-verified-email", false, "Only match Gerrit accounts by email if they confirmed the Coder email")
+	allowDomains := flag.StringSlice("allow-email-domain", nil, "Only synchronize Coder users whose email is in one of these domains; may be repeated")
+	denyDomains := flag.StringSlice("deny-email-domain", nil, "Never synchronize Coder users whose email is in one of these domains; may be repeated")
+	includeGroups := flag.StringSlice("include-group", nil, "Only synchronize members of these Coder groups, given as group or organization/group, or of --include-organization; may be repeated")
+	excludeGroups := flag.StringSlice("exclude-group", nil, "Never synchronize members of these Coder groups, given as group or organization/group; may be repeated")
+	includeOrgs := flag.StringSlice("include-organization", nil, "Only synchronize members of these Coder organizations, or of --include-group; may be repeated")
+	excludeOrgs := flag.StringSlice("exclude-organization", nil, "Never synchronize members of these Coder organizations; may be repeated")
+	loginTypes := flag.StringSlice("only-login-type", nil, "Only synchronize Coder users logging in with these login types: password, github, oidc, token or none; may be repeated")
+	skipRoles := flag.StringSlice("skip-role", nil, "Never synchronize Coder users with these site-wide roles, such as owner or template-admin; may be repeated")
+	minLastSeen := flag.Duration("min-last-seen", 0, "Handle active Coder users not seen in Coder for this long, such as 720h, like suspended or dormant ones according to --inactive-action; disabled if zero")
+	skipServiceAccounts := flag.Bool("skip-service-accounts", false, "Never synchronize Coder service accounts, which have the login type none")
 
 	flag.Parse()
+	if err := applyEnvAndConfig(flag.CommandLine, os.LookupEnv); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	doctor := false
+	switch args := flag.Args(); {
+	case len(args) == 1 && args[0] == doctorCommand:
+		doctor = true
+	case len(args) > 0:
+		log.Fatalf("Error: unexpected arguments %q; the only command is %s, checking the connectivity and permissions", args, doctorCommand)
+	}
 
-	if token == "" {
-		log.Fatal("Error: CODER_SESSION_TOKEN is not set")
+	if err := checkBaseURL(*coderURL); err != nil {
+		log.Fatalf("Error: --coder: %v", err)
+	}
+	if err := checkBaseURL(*gerritInstance); err != nil {
+		log.Fatalf("Error: --gerrit: %v", err)
+	}
+
+	coderToken, err := newSecretSource(os.Getenv("CODER_SESSION_TOKEN"), *tokenFile, *tokenHelper, *coderURL)
+	if err != nil {
+		log.Fatalf("Error: CODER_SESSION_TOKEN, --coder-token-file, --coder-credential-helper: %v", err)
+	}
+	if coderToken.empty() {
+		log.Fatal("Error: CODER_SESSION_TOKEN, --coder-token-file or --coder-credential-helper must be set")
+	}
+	authMode, err := parseGerritAuthMode(*gerritAuthMode)
+	if err != nil {
+		log.Fatalf("Error: --gerrit-auth: %v", err)
+	}
+	gerritAuth, err := newGerritAuth(authMode, *gerritInstance)
+	if err != nil {
+		log.Fatalf("Error: --gerrit: %v", err)
+	}
+	if *gerritUsername == "" {
+		*gerritUsername = os.Getenv("GERRIT_USERNAME")
+	}
+	gerritPassword, err := newSecretSource(os.Getenv("GERRIT_PASSWORD"), *gerritPasswordFile, *gerritHelper, *gerritInstance)
+	if err != nil {
+		log.Fatalf("Error: GERRIT_PASSWORD, --gerrit-password-file, --gerrit-credential-helper: %v", err)
+	}
+	switch {
+	case authMode == gerritAuthCookie:
+		if *gitCookiesFile == "" || !gerritPassword.empty() {
+			log.Fatalf("Error: --gerrit-auth=cookie requires --gerrit-gitcookies-file instead of a password")
+		}
+		gerritPassword = secretSource{file: *gitCookiesFile}
+	case *gitCookiesFile != "":
+		log.Fatalf("Error: --gerrit-gitcookies-file requires --gerrit-auth=cookie")
+	case gerritPassword.empty():
+		log.Fatalf("Error: --gerrit-auth=%s requires GERRIT_PASSWORD, --gerrit-password-file or --gerrit-credential-helper", authMode)
+	case authMode != gerritAuthBearer && *gerritUsername == "" && *gerritHelper == "":
+		log.Fatalf("Error: --gerrit-auth=%s requires --gerrit-username, GERRIT_USERNAME or --gerrit-credential-helper", authMode)
+	}
+
+	selection, err := parseUserSelection(*selectors, *only)
+	if err != nil {
+		log.Fatalf("Error: --select, --only: %v", err)
+	}
+
+	inactiveAction, err := parseInactiveAction(*inactive)
+	if err != nil {
+		log.Fatalf("Error: --inactive-action: %v", err)
+	}
+	if *reactivate && inactiveAction != inactiveActionDeactivate {
+		log.Fatalf("Error: --reactivate-accounts requires --inactive-action=deactivate")
+	}
+
+	outputFormat, err := parseOutputFormat(*output)
+	if err != nil {
+		log.Fatalf("Error: --output: %v", err)
+	}
+
+	if retryPolicy.MaxAttempts < 1 {
+		log.Fatalf("Error: --retry-max-attempts must be at least 1")
+	}
+
+	if err := coderHTTP.check(); err != nil {
+		log.Fatalf("Error: --coder-*: %v", err)
+	}
+	if err := gerritHTTP.check(); err != nil {
+		log.Fatalf("Error: --gerrit-*: %v", err)
+	}
+
+	if *concurrency < 1 {
+		log.Fatalf("Error: --concurrency must be at least 1")
+	}
+
+	if *gerritPageSize < 1 {
+		log.Fatalf("Error: --gerrit-page-size must be at least 1")
+	}
+
+	matchStrategies, err := parseMatchStrategies(*match)
+	if err != nil {
+		log.Fatalf("Error: --match: %v", err)
+	}
+
+	oidc, err := parseOIDCIdentity(*oidcSubjectField, *oidcSchemes)
+	if err != nil {
+		log.Fatalf("Error: --oidc-subject-field, --oidc-external-id-schemes: %v", err)
+	}
+
+	ambiguity, err := parseAmbiguityPolicy(*ambiguous)
+	if err != nil {
+		log.Fatalf("Error: --ambiguous-match: %v", err)
+	}
+
+	var mapping *userMapping
+	if *mappingFile != "" {
+		mapping, err = loadUserMapping(*mappingFile)
+		if err != nil {
+			log.Fatalf("Error: --mapping-file: %v", err)
+		}
+	} else if slices.Contains(matchStrategies, matchMapping) {
+		log.Fatalf("Error: --match=mapping requires --mapping-file")
+	}
+	if err := mapping.checkStrategies(matchStrategies); err != nil {
+		log.Fatalf("Error: --mapping-file, --match: %v", err)
+	}
+
+	aliases, err := parseDomainAliases(*domainAliases)
+	if err != nil {
+		log.Fatalf("Error: --email-domain-alias: %v", err)
+	}
+
+	domains, err := parseEmailDomainFilter(*allowDomains, *denyDomains)
+	if err != nil {
+		log.Fatalf("Error: --allow-email-domain, --deny-email-domain: %v", err)
+	}
+
+	members, err := parseMembershipFilter(*includeGroups, *excludeGroups, *includeOrgs, *excludeOrgs)
+	if err != nil {
+		log.Fatalf("Error: --include-group, --exclude-group, --include-organization, --exclude-organization: %v", err)
+	}
+
+	policy, err := parseUserPolicy(*loginTypes, *skipRoles, *skipServiceAccounts)
+	if err != nil {
+		log.Fatalf("Error: --only-login-type, --skip-role, --skip-service-accounts: %v", err)
+	}
+
+	if *minLastSeen < 0 {
+		log.Fatalf("Error: --min-last-seen must not be negative")
+	}
+
+	if *interval < 0 {
+		log.Fatalf("Error: --interval must not be negative")
 	}
 
 	flag.CommandLine.VisitAll(func(f *flag.Flag) {
@@ -78,62 +370,225 @@ func parseFlags() *config {
 
 	return &config{
 		coderURL:       *coderURL,
-		token:          token,
 		gerritInstance: *gerritInstance,
-		gerritUsername: gerritUsername,
-		gerritPassword: gerritPassword,
-		filterOnly:     *filterOnly,
+		credentials: &credentials{
+			coderToken:     coderToken,
+			gerritUsername: *gerritUsername,
+			gerritPassword: gerritPassword,
+		},
+		gerritAuth:     gerritAuth,
+		doctor:         doctor,
+		coderHTTP:      coderHTTP,
+		gerritHTTP:     gerritHTTP,
+		selection:      selection,
+		coderQuery:     *coderQuery,
+		inactiveAction: inactiveAction,
+		reactivate:     *reactivate,
+		dryRun:         *dryRun,
+		output:         outputFormat,
+		interval:       *interval,
+		jitter:         *jitter,
+		retryPolicy:    retryPolicy,
+		concurrency:    *concurrency,
+		coderQPS:       *coderQPS,
+		gerritQPS:      *gerritQPS,
+
+		prefetchAccounts: *prefetchAccounts,
+		gerritPageSize:   *gerritPageSize,
+
+		matchStrategies: matchStrategies,
+		oidc:            oidc,
+		mapping:         mapping,
+		ambiguity:       ambiguity,
+
+		emails:         emailNormalizer{stripPlusTags: *stripPlusTags, domainAliases: aliases},
+		verifiedEmails: *verifiedEmails,
+		domains:        domains,
+		members:        members,
+		policy:         policy,
+		minLastSeen:    *minLastSeen,
 	}
 }
 
 // newGerritClient initializes and returns a new Gerrit client with authentication.
-// It sets up the client using the provided username and password and API endpoint.
-func newGerritClient(ctx context.Context, path string, gerritUsername string, gerritPassword string) (*gerrit.Client, error) {
+// It sets up the client using the provided username and secret, as used by auth,
+// and API endpoint, sending requests with httpClient.
+func newGerritClient(ctx context.Context, path string, auth *gerritAuth, gerritUsername string, gerritSecret string, httpClient *http.Client) (*gerrit.Client, error) {
+	httpClient.Transport = auth.wrap(httpClient.Transport)
 
 	// Creates a Gerrit client using the provided base URL path.
-	client, err := gerrit.NewClient(ctx, path, nil)
+	client, err := gerrit.NewClient(ctx, auth.baseURL(path), httpClient)
 	if err != nil {
 		return nil, fmt.Errorf("create Gerrit client: %w", err)
 	}
-	// Set authentication if username and password are provided
-	if gerritUsername != "" && gerritPassword != "" {
-		client.Authentication.SetBasicAuth(gerritUsername, gerritPassword)
+	if err := auth.set(client.Authentication, gerritUsername, gerritSecret); err != nil {
+		return nil, fmt.Errorf("set Gerrit authentication: %w", err)
 	}
 
 	return client, nil
 }
 
-// syncUser synchronizes Coder user's SSH key with corresponding Gerrit accounts
-// using client.
+// syncer synchronizes Coder users' SSH keys with corresponding Gerrit accounts.
+type syncer struct {
+	// coder is the client used to read users and their keys from Coder.
+	coder *coderclient.CoderClient
+
+	// gerrit is the service used to read and modify Gerrit accounts.
+	gerrit gerritAccountsService
+
+	// inactiveAction controls how suspended or dormant Coder users are handled.
+	inactiveAction inactiveAction
+
+	// reactivate enables the reactivation of inactive Gerrit accounts of
+	// active Coder users with inactiveActionDeactivate.
+	reactivate bool
+
+	// dryRun disables every modification of Gerrit accounts, which are then
+	// only recorded in plan.
+	dryRun bool
+
+	// plan records every change made, or intended in dry-run mode. It may be
+	// nil.
+	plan *plan
+
+	// locks serializes modifications of each Gerrit account when users are
+	// synchronized concurrently. It may be nil.
+	locks *accountLocks
+
+	// accounts, if set, is used to match users instead of querying Gerrit for
+	// each of them.
+	accounts *gerritAccountIndex
+
+	// matchers are tried in order to find the Gerrit accounts of a user.
+	matchers []matcher
+
+	// mapping lists the Coder users excluded from synchronization. It may be
+	// nil.
+	mapping *userMapping
+
+	// ambiguity selects the accounts synchronized when a user matches several.
+	ambiguity ambiguityPolicy
+
+	// ambiguous reports the users matching several accounts. It may be nil.
+	ambiguous *ambiguityReport
+
+	// emails normalizes the emails compared when matching users.
+	emails emailNormalizer
+
+	// verifiedEmails restricts matches by email to Gerrit accounts that
+	// confirmed the Coder email.
+	verifiedEmails bool
+
+	// skipped counts the users skipped during a pass. It may be nil.
+	skipped *skipCounts
+
+	// policy selects the users synchronized by login type and roles.
+	policy userPolicy
+
+	// minLastSeen, if not zero, makes active users not seen in Coder for that
+	// long be handled as non-active ones.
+	minLastSeen time.Duration
+}
+
+// apply records change in s.plan and performs it by calling do, unless
+// s.dryRun is set.
+func (s *syncer) apply(change plannedChange, do func() error) error {
+	s.plan.record(change)
+	if s.dryRun {
+		log.Printf("Dry run: would %s on Gerrit user %d for Coder user %s", change.Action, change.GerritAccountID, change.CoderUsername)
+		return nil
+	}
+	return do()
+}
+
+// skip records that nothing needs to be done on the Gerrit account accountID
+// on behalf of user, for reason.
+func (s *syncer) skip(user *coderclient.CoderUser, accountID int, reason string) {
+	s.plan.record(newPlannedChange(user, accountID, planActionSkip, reason))
+}
+
+// skipUser records that user is not synchronized, for reason.
+func (s *syncer) skipUser(user *coderclient.CoderUser, reason string) {
+	s.skip(user, 0, reason)
+	s.skipped.add(reason)
+}
+
+// syncUser synchronizes Coder user's SSH key with corresponding Gerrit accounts.
+//
+// Keys previously added on behalf of user that no longer match the current
+// Coder key are deleted. Copies of the current key without the managed comment
+// are replaced by a managed one; other keys without it are never touched.
+// Suspended or dormant users, and users not seen in Coder for s.minLastSeen,
+// are handled according to s.inactiveAction, and so are users rejected by
+// s.policy, as by dropUser.
 //
 // If any step fails, it returns immediate errors or an aggregated error that
 // combines all errors when adding SSH key to Gerrit accounts.
-func syncUser(ctx context.Context, client *coderclient.CoderClient, gAccountService gerritAccountsService, user *coderclient.CoderUser) error {
-	// Make API call to search gerrit account using email
-	if user.Status == coderclient.UserStatusSuspended || user.Status == coderclient.UserStatusDormant {
+func (s *syncer) syncUser(ctx context.Context, user *coderclient.CoderUser) error {
+	if s.mapping.excludes(user) {
+		log.Printf("Skipping sync for Coder user excluded by the mapping file: %q", user)
+		s.skipUser(user, "excluded by mapping file")
+		return nil
+	}
+
+	reason, err := s.policy.reject(ctx, s.coder, user)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return s.dropUser(ctx, user, reason)
+	}
+
+	inactive := user.Status == coderclient.UserStatusSuspended || user.Status == coderclient.UserStatusDormant
+	if inactive && (s.inactiveAction == "" || s.inactiveAction == inactiveActionSkip) {
 		log.Printf("Skipping sync for non-active Coder user: %q", user)
+		s.skipUser(user, "non-active Coder user")
 		return nil
 	}
+	if !inactive && s.minLastSeen > 0 && time.Since(user.LastSeenAt) > s.minLastSeen {
+		// Users that never used Coder have a zero LastSeenAt and are stale too.
+		if s.inactiveAction == "" || s.inactiveAction == inactiveActionSkip {
+			log.Printf("Skipping sync for Coder user not seen since %v: %q", user.LastSeenAt, user)
+			s.skipUser(user, "not seen in Coder recently")
+			return nil
+		}
+		log.Printf("Treating Coder user not seen since %v as non-active: %q", user.LastSeenAt, user)
+		inactive = true
+	}
 
 	log.Printf("Syncing user %q", user)
-	gus, _, err := gAccountService.QueryAccounts(ctx, &gerrit.QueryAccountOptions{
-		QueryOptions: gerrit.QueryOptions{
-			Query: []string{
-				fmt.Sprintf("email:%q", user.Email),
-			},
-		},
-	})
+	gus, err := s.findAccounts(ctx, user)
 	if err != nil {
 		return fmt.Errorf("query Gerrit user: %w", err)
 	}
 
-	if len(*gus) == 0 {
-		log.Printf("No matching Gerrit user for email %q", user.Email)
+	if len(gus) == 0 {
+		log.Printf("No matching Gerrit user for Coder user %q", user)
+		s.skipUser(user, "no matching Gerrit account")
 		return nil
 	}
 
-	var key coderclient.CoderUserGitSSHKeyResponse
-	if err := client.Get(ctx, fmt.Sprintf("/api/v2/users/%s/gitsshkey", user.ID), &key); err != nil {
+	// The ambiguity policy only selects the accounts keys are added to or
+	// that are deactivated: keys managed on behalf of user are removed from
+	// every matched account, since they are known to be Coder's.
+	chosen, err := s.resolveAmbiguity(ctx, user, gus)
+	if err != nil {
+		return fmt.Errorf("resolve ambiguous match: %w", err)
+	}
+	if len(chosen) == 0 {
+		s.skipUser(user, "ambiguous match")
+	}
+
+	if inactive {
+		return s.revokeUser(ctx, user, gus, chosen)
+	}
+
+	key, err := s.coder.GetGitSSHKey(ctx, user.ID)
+	if coderclient.IsUnauthorized(err) || coderclient.IsNotFound(err) {
+		// Coder answers 404 as well when the token may not read the key.
+		return fmt.Errorf("get Coder Git SSH key (does the Coder token have the owner or user-admin role?): %w", err)
+	}
+	if err != nil {
 		return fmt.Errorf("get Coder Git SSH key: %w", err)
 	}
 	if key.PublicKey == "" {
@@ -142,96 +597,628 @@ func syncUser(ctx context.Context, client *coderclient.CoderClient, gAccountServ
 	log.Printf("Got Git SSH key for user %q: %s", user, key.PublicKey)
 
 	var errs []error
-UserLoop:
-	for _, gu := range *gus {
-
-		if gu.Inactive {
-			log.Printf("Skipping inactive Gerrit user AccountID: %d", gu.AccountID)
-			continue
-		}
+	for _, gu := range gus {
 
 		if gu.AccountID <= 0 {
 			log.Printf("Skipping invalid Gerrit user AccountID %d", gu.AccountID)
 			continue
 		}
 
-		existingKeys, _, err := gAccountService.ListSSHKeys(ctx, strconv.Itoa(gu.AccountID))
+		unlock := s.locks.lock(gu.AccountID)
+		errs = append(errs, s.syncAccount(ctx, user, gu, key.PublicKey, containsAccount(chosen, gu.AccountID)))
+		unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// dropUser handles user, which is not synchronized for reason, such as its
+// role or email domain. It is skipped with inactiveActionSkip. Otherwise the
+// keys managed on behalf of user are revoked, so that keys added before user
+// was filtered out do not stay in Gerrit; its accounts are never deactivated,
+// since user is still active in Coder.
+func (s *syncer) dropUser(ctx context.Context, user *coderclient.CoderUser, reason string) error {
+	if s.inactiveAction == "" || s.inactiveAction == inactiveActionSkip {
+		log.Printf("Skipping sync for Coder user %q: %s", user, reason)
+		s.skipUser(user, reason)
+		return nil
+	}
+
+	log.Printf("Revoking Coder-managed SSH keys of Coder user %q: %s", user, reason)
+	gus, err := s.findAccounts(ctx, user)
+	if err != nil {
+		return fmt.Errorf("query Gerrit user: %w", err)
+	}
+	if len(gus) == 0 {
+		s.skipUser(user, reason)
+		return nil
+	}
+	return s.revokeUser(ctx, user, gus, nil)
+}
+
+// findAccounts returns the Gerrit accounts of user, as found by the first of
+// s.matchers finding any. Without matchers, accounts are matched by email.
+func (s *syncer) findAccounts(ctx context.Context, user *coderclient.CoderUser) ([]gerrit.AccountInfo, error) {
+	matchers := s.matchers
+	if len(matchers) == 0 {
+		matchers = []matcher{&emailMatcher{src: s.matchSources()}}
+	}
+
+	for _, m := range matchers {
+		gus, err := m.match(ctx, user)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to get existing SSH keys for Gerrit user %d: %w", gu.AccountID, err))
+			return nil, fmt.Errorf("match by %s: %w", m.strategy(), err)
+		}
+		if len(gus) == 0 {
 			continue
 		}
+		var ids []int
+		for _, gu := range gus {
+			ids = append(ids, gu.AccountID)
+		}
+		log.Printf("Matched Coder user %q to Gerrit users %v by %s", user, ids, m.strategy())
+		return gus, nil
+	}
+	return nil, nil
+}
+
+// matchSources returns the sources of matchers matching users for s.
+func (s *syncer) matchSources() matchSources {
+	return matchSources{
+		coder:    s.coder,
+		gerrit:   s.gerrit,
+		accounts: s.accounts,
+		// Inactive accounts are needed with inactiveActionDeactivate.
+		inactive:       s.inactiveAction == inactiveActionDeactivate,
+		emails:         s.emails,
+		verifiedEmails: s.verifiedEmails,
+	}
+}
+
+// syncAccount adds publicKey, the current Coder key of user, to the Gerrit
+// account gu if add is set, and removes the stale keys managed on behalf of
+// user from it. The caller must hold the lock of the account.
+func (s *syncer) syncAccount(ctx context.Context, user *coderclient.CoderUser, gu gerrit.AccountInfo, publicKey string, add bool) error {
+	if gu.Inactive {
+		if !add || s.inactiveAction != inactiveActionDeactivate || !s.reactivate {
+			log.Printf("Skipping inactive Gerrit user AccountID: %d", gu.AccountID)
+			s.skip(user, gu.AccountID, "inactive Gerrit account")
+			return nil
+		}
+		change := newPlannedChange(user, gu.AccountID, planActionActivate, "active Coder user")
+		if err := s.apply(change, func() error {
+			_, err := s.gerrit.SetActive(ctx, strconv.Itoa(gu.AccountID))
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to reactivate Gerrit user %d: %w", gu.AccountID, err)
+		}
+		log.Printf("Reactivated Gerrit user %d for active Coder user %q", gu.AccountID, user)
+	}
+
+	existingKeys, _, err := s.gerrit.ListSSHKeys(ctx, strconv.Itoa(gu.AccountID))
+	if err != nil {
+		return fmt.Errorf("failed to get existing SSH keys for Gerrit user %d: %w", gu.AccountID, err)
+	}
+
+	normalizedKey := strings.TrimSpace(publicKey)
+	parsedNewKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(normalizedKey))
+	if err != nil {
+		return fmt.Errorf("failed to parse SSH key for user %q: %w", user, err)
+	}
 
-		normalizedKey := strings.TrimSpace(key.PublicKey)
-		parsedNewKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(normalizedKey))
+	exists := false
+	var staleKeys, unmanagedCopies []gerrit.SSHKeyInfo
+	for _, existingKey := range *existingKeys {
+		parsedExistingKey, comment, err := parseGerritSSHKey(existingKey)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to parse SSH key for user %q: %w", user, err))
+			log.Printf("Failed to parse existing SSH key for user %d: %v", gu.AccountID, err)
 			continue
 		}
 
-		for _, existingKey := range *existingKeys {
-			trimmedExisting := strings.TrimSpace(existingKey.SSHPublicKey)
-			parsedExistingKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(trimmedExisting))
-			if err != nil {
-				log.Printf("Failed to parse existing SSH key for user %d: %v", gu.AccountID, err)
+		if slices.Equal(parsedNewKey.Marshal(), parsedExistingKey.Marshal()) {
+			if comment == managedKeyComment(user) {
+				log.Printf("SSH key already exists for Gerrit user %d, skipping...", gu.AccountID)
+				exists = true
 				continue
 			}
+			// The private key was generated by Coder, so a copy without the
+			// managed comment, such as one added by earlier versions, is
+			// taken over: added again with the comment and deleted, so that
+			// it is revoked once Coder regenerates the key.
+			log.Printf("SSH key exists without the managed comment for Gerrit user %d, taking it over", gu.AccountID)
+			unmanagedCopies = append(unmanagedCopies, existingKey)
+			continue
+		}
 
-			if slices.Equal(parsedNewKey.Marshal(), parsedExistingKey.Marshal()) {
-				log.Printf("SSH key already exists (matched by key content) for Gerrit user %d, skipping...", gu.AccountID)
-				continue UserLoop
-			}
+		if comment == managedKeyComment(user) {
+			staleKeys = append(staleKeys, existingKey)
 		}
+	}
 
+	managedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(parsedNewKey))) + " " + managedKeyComment(user)
+	switch {
+	case !add:
+		// The user is recorded as skipped for the ambiguous match.
+	case exists:
+		s.skip(user, gu.AccountID, "SSH key already present")
+	default:
 		log.Printf("Got Gerrit user AccountID %d for Coder user %q", gu.AccountID, user)
-		_, _, err = gAccountService.AddSSHKey(ctx, strconv.Itoa(gu.AccountID), key.PublicKey)
+		change := newPlannedChange(user, gu.AccountID, planActionAdd, "")
+		change.Key = managedKey
+		if err := s.apply(change, func() error {
+			return s.addSSHKey(ctx, gu.AccountID, parsedNewKey, managedKey)
+		}); err != nil {
+			return fmt.Errorf("failed to add SSH key for Gerrit user %d: %w", gu.AccountID, err)
+		}
+		log.Printf("Added SSH key %q: %v", user, managedKey)
+	}
+
+	// Stale keys are only removed once the current key is known to be in
+	// place, so a failed add never leaves the account without a key.
+	errs := s.deleteSSHKeys(ctx, user, gu.AccountID, staleKeys, "stale Coder-managed SSH key")
+	if add {
+		errs = append(errs, s.deleteSSHKeys(ctx, user, gu.AccountID, unmanagedCopies, "Coder SSH key without managed comment")...)
+	}
+	return errors.Join(errs...)
+}
+
+// addSSHKeyAttempts is the number of times addSSHKey tries to add a key.
+const addSSHKeyAttempts = 2
+
+// addSSHKey adds managedKey, the authorized key form of key, to the Gerrit
+// account accountID.
+//
+// Adding a key is not idempotent, and a failed request may still have been
+// applied by Gerrit, so it is never repeated blindly: the keys of the account
+// are listed again first, and the key counts as added if it is present. Keys
+// rejected by Gerrit, such as invalid keys or keys of accounts the caller may
+// not modify, are not added again.
+func (s *syncer) addSSHKey(ctx context.Context, accountID int, key ssh.PublicKey, managedKey string) error {
+	// A copy of key without the managed comment does not count as added.
+	_, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(managedKey))
+	if err != nil {
+		return err
+	}
+	for attempt := 1; attempt <= addSSHKeyAttempts; attempt++ {
+		var resp *gerrit.Response
+		if _, resp, err = s.gerrit.AddSSHKey(ctx, strconv.Itoa(accountID), managedKey); err == nil {
+			return nil
+		}
+		if !isAmbiguousGerritFailure(resp) {
+			return err
+		}
 
+		present, listErr := s.hasSSHKey(ctx, accountID, key, comment)
+		if listErr != nil {
+			return fmt.Errorf("%w; and failed to check whether it was added: %w", err, listErr)
+		}
+		if present {
+			log.Printf("SSH key was added for Gerrit user %d despite error: %v", accountID, err)
+			return nil
+		}
+		log.Printf("SSH key was not added for Gerrit user %d (attempt %d/%d): %v", accountID, attempt, addSSHKeyAttempts, err)
+	}
+	return err
+}
+
+// isAmbiguousGerritFailure reports whether a failed Gerrit request, answered
+// with resp, may have been applied or may succeed if repeated: there was no
+// response, a server error, or a successful response that could not be read.
+func isAmbiguousGerritFailure(resp *gerrit.Response) bool {
+	if resp == nil || resp.Response == nil {
+		return true
+	}
+	return resp.StatusCode < 300 || resp.StatusCode >= 500
+}
+
+// hasSSHKey reports whether the Gerrit account accountID has key with comment.
+func (s *syncer) hasSSHKey(ctx context.Context, accountID int, key ssh.PublicKey, comment string) (bool, error) {
+	existingKeys, _, err := s.gerrit.ListSSHKeys(ctx, strconv.Itoa(accountID))
+	if err != nil {
+		return false, err
+	}
+	for _, existingKey := range *existingKeys {
+		parsedExistingKey, existingComment, err := parseGerritSSHKey(existingKey)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to add SSH key for Gerrit user %d: %w", gu.AccountID, err))
 			continue
 		}
-		log.Printf("Added SSH key %q: %v", user, key.PublicKey)
+		if slices.Equal(key.Marshal(), parsedExistingKey.Marshal()) && existingComment == comment {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// revokeUser removes every SSH key managed on behalf of the non-active Coder
+// user from the matching Gerrit accounts gus, and deactivates those of them
+// chosen by the ambiguity policy when s.inactiveAction is
+// inactiveActionDeactivate.
+func (s *syncer) revokeUser(ctx context.Context, user *coderclient.CoderUser, gus, chosen []gerrit.AccountInfo) error {
+	var errs []error
+	for _, gu := range gus {
+		if gu.AccountID <= 0 {
+			log.Printf("Skipping invalid Gerrit user AccountID %d", gu.AccountID)
+			continue
+		}
 
+		unlock := s.locks.lock(gu.AccountID)
+		errs = append(errs, s.revokeAccount(ctx, user, gu, containsAccount(chosen, gu.AccountID)))
+		unlock()
 	}
 	return errors.Join(errs...)
 }
 
+// revokeAccount removes the SSH keys managed on behalf of the non-active
+// Coder user from the Gerrit account gu, and deactivates it if deactivate is
+// set and s.inactiveAction is inactiveActionDeactivate. The caller must hold
+// the lock of the account.
+func (s *syncer) revokeAccount(ctx context.Context, user *coderclient.CoderUser, gu gerrit.AccountInfo, deactivate bool) error {
+	existingKeys, _, err := s.gerrit.ListSSHKeys(ctx, strconv.Itoa(gu.AccountID))
+	if err != nil {
+		return fmt.Errorf("failed to get existing SSH keys for Gerrit user %d: %w", gu.AccountID, err)
+	}
+
+	var managedKeys []gerrit.SSHKeyInfo
+	for _, existingKey := range *existingKeys {
+		_, comment, err := parseGerritSSHKey(existingKey)
+		if err != nil {
+			log.Printf("Failed to parse existing SSH key for user %d: %v", gu.AccountID, err)
+			continue
+		}
+		if comment == managedKeyComment(user) {
+			managedKeys = append(managedKeys, existingKey)
+		}
+	}
+
+	deleteErrs := s.deleteSSHKeys(ctx, user, gu.AccountID, managedKeys, "non-active Coder user")
+
+	if !deactivate || s.inactiveAction != inactiveActionDeactivate || gu.Inactive {
+		if len(managedKeys) == 0 {
+			s.skip(user, gu.AccountID, "no Coder-managed SSH key")
+		}
+		return errors.Join(deleteErrs...)
+	}
+	// Deactivating an account that may still hold a managed key would
+	// leave that key behind unnoticed once the account is reactivated.
+	if len(deleteErrs) > 0 {
+		return errors.Join(deleteErrs...)
+	}
+	change := newPlannedChange(user, gu.AccountID, planActionDeactivate, "non-active Coder user")
+	if err := s.apply(change, func() error {
+		resp, err := s.gerrit.DeleteActive(ctx, strconv.Itoa(gu.AccountID))
+		// Gerrit answers 409 for an account that is already inactive, as
+		// when a retried deactivation was applied by an earlier attempt.
+		if err != nil && resp != nil && resp.Response != nil && resp.StatusCode == http.StatusConflict {
+			log.Printf("Gerrit user %d is already inactive", gu.AccountID)
+			return nil
+		}
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to deactivate Gerrit user %d: %w", gu.AccountID, err)
+	}
+	log.Printf("Deactivated Gerrit user %d for non-active Coder user %q", gu.AccountID, user)
+	return nil
+}
+
+// deleteSSHKeys deletes keys of user from the Gerrit account
+// accountID for reason, and returns the errors of the deletions that failed.
+//
+// A key that is not found counts as deleted, since a retried deletion finds
+// no key once an earlier attempt was applied.
+func (s *syncer) deleteSSHKeys(ctx context.Context, user *coderclient.CoderUser, accountID int, keys []gerrit.SSHKeyInfo, reason string) []error {
+	var errs []error
+	for _, key := range keys {
+		change := newPlannedChange(user, accountID, planActionRemove, reason)
+		change.Key = strings.TrimSpace(key.SSHPublicKey)
+		if err := s.apply(change, func() error {
+			resp, err := s.gerrit.DeleteSSHKey(ctx, strconv.Itoa(accountID), strconv.Itoa(key.Seq))
+			if err != nil && resp != nil && resp.Response != nil && resp.StatusCode == http.StatusNotFound {
+				log.Printf("SSH key %d for Gerrit user %d is already deleted", key.Seq, accountID)
+				return nil
+			}
+			return err
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete SSH key %d for Gerrit user %d: %w", key.Seq, accountID, err))
+			continue
+		}
+		log.Printf("Deleted SSH key %d for Gerrit user %d: %s", key.Seq, accountID, key.SSHPublicKey)
+	}
+	return errs
+}
+
+// containsAccount reports whether gus contains the Gerrit account accountID.
+func containsAccount(gus []gerrit.AccountInfo, accountID int) bool {
+	return slices.ContainsFunc(gus, func(gu gerrit.AccountInfo) bool { return gu.AccountID == accountID })
+}
+
+// parseGerritSSHKey parses an SSH key stored in Gerrit and returns the public
+// key together with its comment.
+func parseGerritSSHKey(key gerrit.SSHKeyInfo) (ssh.PublicKey, string, error) {
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(key.SSHPublicKey)))
+	return pub, comment, err
+}
+
 func main() {
 	ctx := context.Background()
 	log.Printf("version: %s\n", version.Version)
 
 	config := parseFlags()
 
+	coderToken, gerritUsername, gerritPassword, err := config.credentials.load(ctx)
+	if err != nil {
+		log.Fatalf("Load credentials: %v", err)
+	}
+
+	gerritHTTPClient, err := newHTTPClient(config.retryPolicy, config.gerritQPS, config.gerritHTTP)
+	if err != nil {
+		log.Fatalf("Error: --gerrit-*: %v", err)
+	}
+	coderHTTPClient, err := newHTTPClient(config.retryPolicy, config.coderQPS, config.coderHTTP)
+	if err != nil {
+		log.Fatalf("Error: --coder-*: %v", err)
+	}
+
 	// Initialize gerrit client
-	gClient, err := newGerritClient(ctx, config.gerritInstance, config.gerritUsername, config.gerritPassword)
+	gClient, err := newGerritClient(ctx, config.gerritInstance, config.gerritAuth, gerritUsername, gerritPassword, gerritHTTPClient)
 	if err != nil {
 		log.Fatalf("Failed to initialize Gerrit client: %v", err)
 	}
 
+	cClient := coderclient.NewCoderClient(config.coderURL, coderToken, coderclient.WithHTTPClient(coderHTTPClient))
+
+	if config.doctor {
+		if !runChecks(ctx, os.Stdout,
+			coderChecks(cClient, coderToken, config.members, time.Now),
+			gerritChecks(gClient, config.mapping),
+		) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	gv, _, err := gClient.Config.GetVersion(ctx)
 	if err != nil {
 		log.Fatalf("Check Gerrit version: %v", err)
 	}
 	log.Printf("Gerrit version: %s", gv)
 
-	cClient := coderclient.NewCoderClient(config.coderURL, config.token)
+	self, err := checkGerritIdentity(ctx, gClient.Accounts)
+	if err != nil {
+		log.Fatalf("Check Gerrit credentials: %v (are the credentials of --gerrit-auth valid?)", err)
+	}
+	log.Printf("Gerrit user: %s (%d)", self.Username, self.AccountID)
+
+	if config.mapping != nil {
+		if err := config.mapping.resolve(ctx, gClient.Accounts); err != nil {
+			log.Fatalf("Check --mapping-file: %v", err)
+		}
+	}
 
-	var bi coderclient.CoderBuildInfoResponse
-	if err := cClient.Get(ctx, "/api/v2/buildinfo", &bi); err != nil {
+	bi, err := cClient.BuildInfo(ctx)
+	if err != nil {
 		log.Fatalf("Check Coder version: %v", err)
 	}
+	// buildinfo is public, so check the token early with an authenticated call.
+	if _, err := cClient.GetUser(ctx, "me"); err != nil {
+		if coderclient.IsUnauthorized(err) {
+			log.Fatalf("Check Coder credentials: %v (is CODER_SESSION_TOKEN valid and unexpired?)", err)
+		}
+		log.Fatalf("Check Coder credentials: %v", err)
+	}
 	log.Printf("Coder version: %s", bi.Version)
 
-	var cus coderclient.CoderUsersResponse
-	if err := cClient.Get(ctx, "/api/v2/users", &cus); err != nil {
-		log.Fatalf("List Coder users: %v", err)
+	s := &syncer{
+		coder:          cClient,
+		gerrit:         gClient.Accounts,
+		inactiveAction: config.inactiveAction,
+		reactivate:     config.reactivate,
+		dryRun:         config.dryRun,
+		locks:          &accountLocks{},
+		mapping:        config.mapping,
+		ambiguity:      config.ambiguity,
+		policy:         config.policy,
+		minLastSeen:    config.minLastSeen,
+		emails:         config.emails,
+		verifiedEmails: config.verifiedEmails,
 	}
 
-	for _, cu := range cus.Users {
-		if config.filterOnly != "" && cu.Email != config.filterOnly {
-			continue
+	// Requests use ctx, which is never cancelled, so that a signal lets the
+	// user in flight finish instead of leaving it half synchronized.
+	stop, cancel := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	if config.interval == 0 {
+		if err := reconcile(ctx, stop, config, s); err != nil {
+			log.Fatalf("Synchronization failed: %v", err)
+		}
+		return
+	}
+	runDaemon(stop, config.interval, config.jitter, func() {
+		// Pick up rotated secrets; the previous ones are kept on failure.
+		if err := config.credentials.reload(ctx, cClient, func(username, secret string) error {
+			return config.gerritAuth.set(gClient.Authentication, username, secret)
+		}); err != nil {
+			log.Printf("Reload credentials: %v", err)
+		}
+		if err := reconcile(ctx, stop, config, s); err != nil {
+			log.Printf("Synchronization failed: %v", err)
+		}
+	})
+	log.Printf("Stopped")
+}
+
+// newHTTPClient returns an HTTP client connecting as configured by opts,
+// sending at most qps requests per second, or without limit if qps is zero,
+// and retrying failed requests according to policy.
+func newHTTPClient(policy retry.Policy, qps float64, opts *httpOptions) (*http.Client, error) {
+	base, err := opts.transport()
+	if err != nil {
+		return nil, err
+	}
+	limited := &ratelimit.Transport{Base: base, Limiter: ratelimit.NewLimiter(qps)}
+	transport := retry.NewTransport(limited, policy)
+	transport.Logf = log.Printf
+	if opts != nil {
+		transport.AttemptTimeout = opts.timeout
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// listedUser is a Coder user listed by reconcile, to synchronize or, if
+// reason is set, to drop for reason.
+type listedUser struct {
+	user   coderclient.CoderUser
+	reason string
+}
+
+// userError is the error of synchronizing a single Coder user.
+type userError struct {
+	user coderclient.CoderUser
+	err  error
+}
+
+// reconcile runs a single synchronization pass over every Coder user using s,
+// with config.concurrency users synchronized concurrently. Once stop is done,
+// users in flight are finished but no further user is started.
+//
+// Errors of individual users are combined at the end of the pass, ordered by
+// username so that reports of different passes can be compared, into the
+// returned error. In dry-run mode, the changes of the pass are printed to
+// stdout.
+func reconcile(ctx, stop context.Context, config *config, s *syncer) error {
+	if s.dryRun {
+		s.plan = &plan{}
+	}
+	s.ambiguous = &ambiguityReport{}
+	s.skipped = &skipCounts{}
+
+	if config.prefetchAccounts {
+		queries := []string{"is:active"}
+		if s.inactiveAction == inactiveActionDeactivate {
+			// Inactive accounts may still hold keys of non-active Coder users,
+			// and get reactivated for active ones with --reactivate-accounts.
+			queries = append(queries, "is:inactive")
+		}
+		accounts, err := loadGerritAccountIndex(ctx, s.gerrit, queries, config.gerritPageSize, s.emails)
+		if err != nil {
+			return fmt.Errorf("prefetch Gerrit accounts: %w", err)
+		}
+		if slices.Contains(config.matchStrategies, matchExternalID) {
+			if err := accounts.indexExternalIDs(ctx, s.gerrit, config.oidc); err != nil {
+				return fmt.Errorf("prefetch Gerrit external IDs: %w", err)
+			}
+		}
+		s.accounts = accounts
+	}
+	// Membership is resolved once per pass, not for every user.
+	members, err := config.members.resolve(ctx, s.coder)
+	if err != nil {
+		return fmt.Errorf("resolve Coder group membership: %w", err)
+	}
+
+	src := s.matchSources()
+	src.oidc = config.oidc
+	src.mapping = config.mapping
+	s.matchers = newMatchers(config.matchStrategies, src)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []userError
+		synced   int
+
+		// listed counts the users listed before --select is applied, to be
+		// compared with the count reported by Coder.
+		listed      int
+		count       int
+		interrupted bool
+	)
+	users := make(chan listedUser)
+	for range max(config.concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range users {
+				cu := u.user
+				var err error
+				if u.reason != "" {
+					err = s.dropUser(ctx, &cu, u.reason)
+				} else {
+					err = s.syncUser(ctx, &cu)
+				}
+				mu.Lock()
+				synced++
+				if err != nil {
+					failures = append(failures, userError{user: cu, err: err})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	listErr := func() error {
+		defer close(users)
+		for cu, err := range s.coder.ListUsers(ctx, &coderclient.ListUsersOptions{Query: config.coderQuery, Count: &count}) {
+			if err != nil {
+				return fmt.Errorf("list Coder users: %w", err)
+			}
+			listed++
+			if !config.selection.selects(&cu) {
+				continue
+			}
+			reason := config.domains.reject(cu.Email, s.emails)
+			if reason == "" {
+				reason = members.reject(&cu)
+			}
+			if reason != "" && (s.inactiveAction == "" || s.inactiveAction == inactiveActionSkip) {
+				// Filtered users are not looked up in Gerrit unless their
+				// keys are revoked.
+				log.Printf("Skipping sync for Coder user %q: %s", &cu, reason)
+				s.skipUser(&cu, reason)
+				continue
+			}
+			// Check stop first, since select picks randomly among ready cases.
+			if stop.Err() == nil {
+				select {
+				case users <- listedUser{user: cu, reason: reason}:
+					continue
+				case <-stop.Done():
+				}
+			}
+			log.Printf("Interrupted, not syncing remaining users")
+			interrupted = true
+			return nil
+		}
+		return nil
+	}()
+	wg.Wait()
+
+	slices.SortFunc(failures, func(a, b userError) int {
+		return cmp.Or(cmp.Compare(a.user.Username, b.user.Username), cmp.Compare(a.user.ID, b.user.ID))
+	})
+	log.Printf("Synchronized %d users, %d failed", synced, len(failures))
+	s.skipped.log()
+	s.ambiguous.log()
+	if listErr == nil && !interrupted && listed != count {
+		// Users added or removed while listing also cause a mismatch.
+		log.Printf("Warning: listed %d Coder users, but Coder reported %d", listed, count)
+	}
+
+	var failuresErr error
+	if len(failures) > 0 {
+		errs := []error{fmt.Errorf("%d of %d users failed", len(failures), synced)}
+		for _, f := range failures {
+			errs = append(errs, fmt.Errorf("sync user %q: %w", &f.user, f.err))
 		}
-		if err := syncUser(ctx, cClient, gClient.Accounts, &cu); err != nil {
-			log.Printf("Error syncing user %q: %v", cu, err)
+		failuresErr = errors.Join(errs...)
+	}
+
+	if listErr != nil {
+		return errors.Join(listErr, failuresErr)
+	}
+	if s.plan != nil {
+		if err := s.plan.write(os.Stdout, config.output); err != nil {
+			return fmt.Errorf("print planned changes: %w", err)
 		}
 	}
+	return failuresErr
 }
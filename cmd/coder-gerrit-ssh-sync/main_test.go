@@ -9,26 +9,44 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"github.com/andygrunwald/go-gerrit"
+	"github.com/google/go-cmp/cmp"
 	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
 	"github.com/stretchr/testify/mock"
 )
 
 type MockGerritClient struct {
 	mock.Mock
-	QueryResult       []gerrit.AccountInfo
-	QueryErr          error
-	AddSSHKeyErr      error
+	QueryResult  []gerrit.AccountInfo
+	QueryErr     error
+	AddSSHKeyErr error
+	// AddSSHKeyStatus, if set, is the status code of the failed AddSSHKey.
+	AddSSHKeyStatus   int
 	ListSSHKeysResult []gerrit.SSHKeyInfo
 	ListSSHKeysErr    error
+	// ListSSHKeysAfterAdd, if set, is returned by ListSSHKeys once AddSSHKey
+	// has been called, to simulate an add applied despite an error.
+	ListSSHKeysAfterAdd []gerrit.SSHKeyInfo
+	DeleteSSHKeyErr     error
+	// DeleteSSHKeyStatus, if set, is the status code of the failed DeleteSSHKey.
+	DeleteSSHKeyStatus int
+	DeleteActiveErr    error
+	// DeleteActiveStatus, if set, is the status code of the failed DeleteActive.
+	DeleteActiveStatus int
+	SetActiveErr       error
+	// Queries records the queries passed to QueryAccounts.
+	Queries []string
 }
 
 // QueryAccounts simulates the QueryAccounts in Gerrit and returns preconfigured mock data and errors.
 func (m *MockGerritClient) QueryAccounts(ctx context.Context, opts *gerrit.QueryAccountOptions) (*[]gerrit.AccountInfo, *gerrit.Response, error) {
+	m.Queries = append(m.Queries, opts.Query...)
 
 	if m.QueryErr != nil {
 		return nil, nil, m.QueryErr
@@ -62,9 +80,75 @@ func (m *MockGerritClient) ListSSHKeys(ctx context.Context, accountID string) (*
 		},
 	}
 
+	if m.ListSSHKeysAfterAdd != nil && len(m.Calls) > 0 {
+		for _, c := range m.Calls {
+			if c.Method == "AddSSHKey" {
+				return &m.ListSSHKeysAfterAdd, mockResponse, nil
+			}
+		}
+	}
+
 	return &m.ListSSHKeysResult, mockResponse, nil
 }
 
+// DeleteSSHKey simulates DeleteSSHKey in Gerrit and returns preconfigured mock data and errors.
+func (m *MockGerritClient) DeleteSSHKey(ctx context.Context, accountID, sshKeyID string) (*gerrit.Response, error) {
+	args := m.Called(ctx, accountID, sshKeyID)
+
+	return args.Get(0).(*gerrit.Response), args.Error(1)
+}
+
+// DeleteActive simulates DeleteActive in Gerrit and returns preconfigured mock data and errors.
+func (m *MockGerritClient) DeleteActive(ctx context.Context, accountID string) (*gerrit.Response, error) {
+	args := m.Called(ctx, accountID)
+
+	return args.Get(0).(*gerrit.Response), args.Error(1)
+}
+
+// SetActive simulates SetActive in Gerrit and returns preconfigured mock data and errors.
+func (m *MockGerritClient) SetActive(ctx context.Context, accountID string) (*gerrit.Response, error) {
+	args := m.Called(ctx, accountID)
+
+	return args.Get(0).(*gerrit.Response), args.Error(1)
+}
+
+// GetAccount simulates GetAccount in Gerrit and returns preconfigured mock data and errors.
+func (m *MockGerritClient) GetAccount(ctx context.Context, account string) (*gerrit.AccountInfo, *gerrit.Response, error) {
+	args := m.Called(ctx, account)
+
+	return args.Get(0).(*gerrit.AccountInfo), args.Get(1).(*gerrit.Response), args.Error(2)
+}
+
+// GetAccountExternalIDs simulates GetAccountExternalIDs in Gerrit and returns preconfigured mock data and errors.
+func (m *MockGerritClient) GetAccountExternalIDs(ctx context.Context, accountID string) (*[]gerrit.AccountExternalIdInfo, *gerrit.Response, error) {
+	args := m.Called(ctx, accountID)
+
+	return args.Get(0).(*[]gerrit.AccountExternalIdInfo), args.Get(1).(*gerrit.Response), args.Error(2)
+}
+
+// GetAccountDetails simulates GetAccountDetails in Gerrit and returns preconfigured mock data and errors.
+func (m *MockGerritClient) GetAccountDetails(ctx context.Context, accountID string) (*gerrit.AccountDetailInfo, *gerrit.Response, error) {
+	args := m.Called(ctx, accountID)
+
+	return args.Get(0).(*gerrit.AccountDetailInfo), args.Get(1).(*gerrit.Response), args.Error(2)
+}
+
+// ListAccountEmails simulates ListAccountEmails in Gerrit and returns preconfigured mock data and errors.
+func (m *MockGerritClient) ListAccountEmails(ctx context.Context, accountID string) (*[]gerrit.EmailInfo, *gerrit.Response, error) {
+	args := m.Called(ctx, accountID)
+
+	return args.Get(0).(*[]gerrit.EmailInfo), args.Get(1).(*gerrit.Response), args.Error(2)
+}
+
+// mockResponse returns a Gerrit response with status, or without HTTP
+// response, as for network errors, if status is zero.
+func mockResponse(status int) *gerrit.Response {
+	if status == 0 {
+		return &gerrit.Response{}
+	}
+	return &gerrit.Response{Response: &http.Response{StatusCode: status}}
+}
+
 func generateTestSSHKey(t *testing.T) string {
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -82,6 +166,8 @@ func generateTestSSHKey(t *testing.T) string {
 func TestSyncUser(t *testing.T) {
 	ctx := context.Background()
 	testNormalizedSSHKey := generateTestSSHKey(t)
+	testManagedSSHKey := testNormalizedSSHKey + " coder-gerrit-ssh-sync:user123"
+	staleSSHKey := generateTestSSHKey(t)
 
 	testCases := []struct {
 		name         string
@@ -91,6 +177,15 @@ func TestSyncUser(t *testing.T) {
 		expectErr    bool
 		expectedIDs  []string
 		expectedKey  string
+		// expectedDeletes lists "accountID/seq" pairs expected to be deleted.
+		expectedDeletes     []string
+		inactiveAction      inactiveAction
+		reactivate          bool
+		expectedDeactivates []string
+		expectedActivates   []string
+		minLastSeen         time.Duration
+		ambiguity           ambiguityPolicy
+		policy              userPolicy
 	}{
 		{
 			// Successfully sync user.
@@ -111,7 +206,7 @@ func TestSyncUser(t *testing.T) {
 			},
 			expectErr:   false,
 			expectedIDs: []string{"123"},
-			expectedKey: testNormalizedSSHKey,
+			expectedKey: testManagedSSHKey,
 		},
 		{
 			// QueryAccount failed to retrieve gerrit account.
@@ -183,7 +278,28 @@ func TestSyncUser(t *testing.T) {
 			},
 			expectErr:   true,
 			expectedIDs: []string{"123"},
-			expectedKey: testNormalizedSSHKey,
+			expectedKey: testManagedSSHKey,
+		},
+		{
+			// Key rejected by Gerrit is not added again.
+			name: "AddSSHKey_rejected",
+			mockGerrit: &MockGerritClient{
+				Mock:            mock.Mock{},
+				QueryResult:     []gerrit.AccountInfo{{AccountID: 123}},
+				AddSSHKeyErr:    errors.New("400 Bad Request"),
+				AddSSHKeyStatus: http.StatusBadRequest,
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+			},
+			expectErr:   true,
+			expectedIDs: []string{"123"},
+			expectedKey: testManagedSSHKey,
 		},
 		{
 			// Multiple AddSSHKey calls.
@@ -207,7 +323,7 @@ func TestSyncUser(t *testing.T) {
 			},
 			expectErr:   false,
 			expectedIDs: []string{"123", "456"},
-			expectedKey: testNormalizedSSHKey,
+			expectedKey: testManagedSSHKey,
 		},
 		{
 			//  Gerrit accountId is invalid.
@@ -265,7 +381,7 @@ func TestSyncUser(t *testing.T) {
 			name: "Key_Already_Exists",
 			mockGerrit: &MockGerritClient{
 				QueryResult:       []gerrit.AccountInfo{{AccountID: 123}},
-				ListSSHKeysResult: []gerrit.SSHKeyInfo{{SSHPublicKey: testNormalizedSSHKey}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{{Seq: 3, SSHPublicKey: testManagedSSHKey}},
 				QueryErr:          nil,
 			},
 			mockResponse: func(w http.ResponseWriter, r *http.Request) {
@@ -277,6 +393,27 @@ func TestSyncUser(t *testing.T) {
 			},
 			expectErr: false,
 		},
+		{
+			// A copy of the Coder key without comment, as added by earlier
+			// versions, is taken over.
+			name: "Unmanaged_Copy_Taken_Over",
+			mockGerrit: &MockGerritClient{
+				Mock:              mock.Mock{},
+				QueryResult:       []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{{Seq: 3, SSHPublicKey: testNormalizedSSHKey}},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email: "test@example.com",
+				ID:    "user123",
+			},
+			expectErr:       false,
+			expectedIDs:     []string{"123"},
+			expectedKey:     testManagedSSHKey,
+			expectedDeletes: []string{"123/3"},
+		},
 		{
 			// Non-active Coder user: Suspended
 			name: "Suspended_Coder_User",
@@ -295,7 +432,7 @@ func TestSyncUser(t *testing.T) {
 			},
 			expectErr:   false,
 			expectedIDs: []string{},
-			expectedKey: testNormalizedSSHKey,
+			expectedKey: testManagedSSHKey,
 		},
 		{
 			// Non-active Coder user: Dormant
@@ -315,16 +452,16 @@ func TestSyncUser(t *testing.T) {
 			},
 			expectErr:   false,
 			expectedIDs: []string{},
-			expectedKey: testNormalizedSSHKey,
+			expectedKey: testManagedSSHKey,
 		},
 		{
-			// Key Already Exists including Comments
-			name: "Key_Equality_Ignores_Comment",
+			// A copy of the Coder key with another comment is taken over.
+			name: "Unmanaged_Copy_With_Comment_Taken_Over",
 			mockGerrit: &MockGerritClient{
 				Mock:        mock.Mock{},
 				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
 				ListSSHKeysResult: []gerrit.SSHKeyInfo{
-					{SSHPublicKey: testNormalizedSSHKey + " some-comment"},
+					{Seq: 4, SSHPublicKey: testNormalizedSSHKey + " some-comment"},
 				},
 				QueryErr: nil,
 			},
@@ -336,9 +473,511 @@ func TestSyncUser(t *testing.T) {
 				ID:       "user-comment-test",
 				Username: "comment-tester",
 			},
+			expectErr:       false,
+			expectedIDs:     []string{"123"},
+			expectedKey:     testNormalizedSSHKey + " coder-gerrit-ssh-sync:user-comment-test",
+			expectedDeletes: []string{"123/4"},
+		},
+		{
+			// Stale key previously added for this Coder user is removed.
+			name: "Stale_Managed_Key_Deleted",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 7, SSHPublicKey: staleSSHKey + " coder-gerrit-ssh-sync:user123"},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+			},
+			expectErr:       false,
+			expectedIDs:     []string{"123"},
+			expectedKey:     testManagedSSHKey,
+			expectedDeletes: []string{"123/7"},
+		},
+		{
+			// Stale key is removed even when the current key already exists.
+			name: "Stale_Managed_Key_Deleted_Key_Exists",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+					{Seq: 2, SSHPublicKey: staleSSHKey + " coder-gerrit-ssh-sync:user123"},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+			},
+			expectErr:       false,
+			expectedDeletes: []string{"123/2"},
+		},
+		{
+			// Keys added by the user or for another Coder user are never removed.
+			name: "Unmanaged_Keys_Kept",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: staleSSHKey + " laptop"},
+					{Seq: 2, SSHPublicKey: staleSSHKey + " coder-gerrit-ssh-sync:other-user"},
+					{Seq: 3, SSHPublicKey: staleSSHKey},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+			},
 			expectErr:   false,
-			expectedIDs: []string{},
-			expectedKey: testNormalizedSSHKey,
+			expectedIDs: []string{"123"},
+			expectedKey: testManagedSSHKey,
+		},
+		{
+			// Stale key is kept when adding the current key fails.
+			name: "Stale_Managed_Key_Kept_On_Add_Failure",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 7, SSHPublicKey: staleSSHKey + " coder-gerrit-ssh-sync:user123"},
+				},
+				AddSSHKeyErr: errors.New("failed to add SSH key"),
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+			},
+			expectErr:   true,
+			expectedIDs: []string{"123"},
+			expectedKey: testManagedSSHKey,
+		},
+		{
+			// Failed to delete stale key from Gerrit.
+			name: "DeleteSSHKey_fail",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+					{Seq: 2, SSHPublicKey: staleSSHKey + " coder-gerrit-ssh-sync:user123"},
+				},
+				DeleteSSHKeyErr: errors.New("failed to delete SSH key"),
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+			},
+			expectErr:       true,
+			expectedDeletes: []string{"123/2"},
+		},
+		{
+			// Stale key already deleted, e.g. by a retried request.
+			name: "DeleteSSHKey_not_found",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+					{Seq: 2, SSHPublicKey: staleSSHKey + " coder-gerrit-ssh-sync:user123"},
+				},
+				DeleteSSHKeyErr:    errors.New("404 Not Found"),
+				DeleteSSHKeyStatus: http.StatusNotFound,
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+			},
+			expectErr:       false,
+			expectedDeletes: []string{"123/2"},
+		},
+		{
+			// Suspended Coder user has managed keys revoked.
+			name: "Suspended_Coder_User_Revoke",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+					{Seq: 2, SSHPublicKey: staleSSHKey + " laptop"},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "suspendedUser@example.com",
+				ID:       "user123",
+				Username: "suspendedUser",
+				Status:   coderclient.UserStatusSuspended,
+			},
+			expectErr:       false,
+			inactiveAction:  inactiveActionRevoke,
+			expectedDeletes: []string{"123/1"},
+		},
+		{
+			// Active Coder user not seen recently has managed keys revoked.
+			name: "Stale_Coder_User_Revoke",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:      "staleUser@example.com",
+				ID:         "user123",
+				Username:   "staleUser",
+				Status:     coderclient.UserStatusActive,
+				LastSeenAt: time.Now().Add(-60 * 24 * time.Hour),
+			},
+			expectErr:       false,
+			inactiveAction:  inactiveActionRevoke,
+			minLastSeen:     30 * 24 * time.Hour,
+			expectedDeletes: []string{"123/1"},
+		},
+		{
+			// Active Coder user that never used Coder is not provisioned.
+			name: "Never_Seen_Coder_User_Skip",
+			mockGerrit: &MockGerritClient{
+				Mock:     mock.Mock{},
+				QueryErr: errors.New("must not query Gerrit"),
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "newUser@example.com",
+				ID:       "user123",
+				Username: "newUser",
+				Status:   coderclient.UserStatusActive,
+			},
+			expectErr:   false,
+			minLastSeen: 30 * 24 * time.Hour,
+		},
+		{
+			// Active Coder user seen recently is synced.
+			name: "Recently_Seen_Coder_User",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:      "test@example.com",
+				ID:         "user123",
+				Username:   "testUser1",
+				Status:     coderclient.UserStatusActive,
+				LastSeenAt: time.Now().Add(-24 * time.Hour),
+			},
+			expectErr:      false,
+			inactiveAction: inactiveActionRevoke,
+			minLastSeen:    30 * 24 * time.Hour,
+			expectedIDs:    []string{"123"},
+			expectedKey:    testManagedSSHKey,
+		},
+		{
+			// Dormant Coder user has managed keys revoked and account deactivated.
+			name: "Dormant_Coder_User_Deactivate",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}, {AccountID: 456, Inactive: true}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "dormantUser@example.com",
+				ID:       "user123",
+				Username: "dormantUser",
+				Status:   coderclient.UserStatusDormant,
+			},
+			expectErr:           false,
+			inactiveAction:      inactiveActionDeactivate,
+			expectedDeletes:     []string{"123/1", "456/1"},
+			expectedDeactivates: []string{"123"},
+		},
+		{
+			// Managed keys are revoked from every account of an ambiguous
+			// match, but accounts not chosen are not deactivated.
+			name: "Ambiguous_Refused_Revoke",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}, {AccountID: 456}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "suspendedUser@example.com",
+				ID:       "user123",
+				Username: "suspendedUser",
+				Status:   coderclient.UserStatusSuspended,
+			},
+			expectErr:       false,
+			inactiveAction:  inactiveActionDeactivate,
+			ambiguity:       ambiguityRefuse,
+			expectedDeletes: []string{"123/1", "456/1"},
+		},
+		{
+			// Stale managed keys are removed from every account of an
+			// ambiguous match, but the current key is added to none.
+			name: "Ambiguous_Refused_Stale_Keys_Removed",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}, {AccountID: 456}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: staleSSHKey + " coder-gerrit-ssh-sync:user123"},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+				Status:   coderclient.UserStatusActive,
+			},
+			expectErr:       false,
+			ambiguity:       ambiguityRefuse,
+			expectedDeletes: []string{"123/1", "456/1"},
+		},
+		{
+			// Keys of users rejected by the policy are revoked, but their
+			// accounts are not deactivated.
+			name: "Rejected_By_Policy_Revoke",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+				},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "admin@example.com",
+				ID:       "user123",
+				Username: "admin",
+				Status:   coderclient.UserStatusActive,
+				Roles:    []coderclient.CoderRole{{Name: coderclient.RoleOwner}},
+			},
+			expectErr:       false,
+			inactiveAction:  inactiveActionDeactivate,
+			policy:          userPolicy{skipRoles: []string{coderclient.RoleOwner}},
+			expectedDeletes: []string{"123/1"},
+		},
+		{
+			// Users rejected by the policy are left alone with the skip action.
+			name: "Rejected_By_Policy_Skip",
+			mockGerrit: &MockGerritClient{
+				Mock:     mock.Mock{},
+				QueryErr: errors.New("must not query Gerrit"),
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "admin@example.com",
+				ID:       "user123",
+				Username: "admin",
+				Status:   coderclient.UserStatusActive,
+				Roles:    []coderclient.CoderRole{{Name: coderclient.RoleOwner}},
+			},
+			expectErr: false,
+			policy:    userPolicy{skipRoles: []string{coderclient.RoleOwner}},
+		},
+		{
+			// Account is kept active when a managed key could not be removed.
+			name: "Deactivate_Skipped_On_Delete_Failure",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123}},
+				ListSSHKeysResult: []gerrit.SSHKeyInfo{
+					{Seq: 1, SSHPublicKey: testManagedSSHKey},
+				},
+				DeleteSSHKeyErr: errors.New("failed to delete SSH key"),
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "suspendedUser@example.com",
+				ID:       "user123",
+				Username: "suspendedUser",
+				Status:   coderclient.UserStatusSuspended,
+			},
+			expectErr:       true,
+			inactiveAction:  inactiveActionDeactivate,
+			expectedDeletes: []string{"123/1"},
+		},
+		{
+			// Failed to deactivate Gerrit account.
+			name: "DeleteActive_fail",
+			mockGerrit: &MockGerritClient{
+				Mock:            mock.Mock{},
+				QueryResult:     []gerrit.AccountInfo{{AccountID: 123}},
+				DeleteActiveErr: errors.New("failed to deactivate"),
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "suspendedUser@example.com",
+				ID:       "user123",
+				Username: "suspendedUser",
+				Status:   coderclient.UserStatusSuspended,
+			},
+			expectErr:           true,
+			inactiveAction:      inactiveActionDeactivate,
+			expectedDeactivates: []string{"123"},
+		},
+		{
+			// Gerrit account already deactivated, such as by an earlier attempt.
+			name: "DeleteActive_Conflict",
+			mockGerrit: &MockGerritClient{
+				Mock:               mock.Mock{},
+				QueryResult:        []gerrit.AccountInfo{{AccountID: 123}},
+				DeleteActiveErr:    errors.New("account not active"),
+				DeleteActiveStatus: http.StatusConflict,
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "suspendedUser@example.com",
+				ID:       "user123",
+				Username: "suspendedUser",
+				Status:   coderclient.UserStatusSuspended,
+			},
+			expectErr:           false,
+			inactiveAction:      inactiveActionDeactivate,
+			expectedDeactivates: []string{"123"},
+		},
+		{
+			// Inactive Gerrit account is reactivated and synced for active Coder user.
+			name: "Reactivate_Inactive_AccountID",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123, Inactive: true}},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+				Status:   coderclient.UserStatusActive,
+			},
+			expectErr:         false,
+			inactiveAction:    inactiveActionDeactivate,
+			reactivate:        true,
+			expectedActivates: []string{"123"},
+			expectedIDs:       []string{"123"},
+			expectedKey:       testManagedSSHKey,
+		},
+		{
+			// Inactive Gerrit account is left alone without --reactivate-accounts.
+			name: "Inactive_AccountID_Not_Reactivated",
+			mockGerrit: &MockGerritClient{
+				Mock:        mock.Mock{},
+				QueryResult: []gerrit.AccountInfo{{AccountID: 123, Inactive: true}},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+				Status:   coderclient.UserStatusActive,
+			},
+			expectErr:      false,
+			inactiveAction: inactiveActionDeactivate,
+		},
+		{
+			// Failed to reactivate Gerrit account.
+			name: "SetActive_fail",
+			mockGerrit: &MockGerritClient{
+				Mock:         mock.Mock{},
+				QueryResult:  []gerrit.AccountInfo{{AccountID: 123, Inactive: true}},
+				SetActiveErr: errors.New("failed to reactivate"),
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+				Status:   coderclient.UserStatusActive,
+			},
+			expectErr:         true,
+			inactiveAction:    inactiveActionDeactivate,
+			reactivate:        true,
+			expectedActivates: []string{"123"},
+		},
+		{
+			// AddSSHKey reports an error but the key was added.
+			name: "AddSSHKey_fail_but_applied",
+			mockGerrit: &MockGerritClient{
+				Mock:                mock.Mock{},
+				QueryResult:         []gerrit.AccountInfo{{AccountID: 123}},
+				AddSSHKeyErr:        errors.New("connection reset by peer"),
+				ListSSHKeysAfterAdd: []gerrit.SSHKeyInfo{{Seq: 1, SSHPublicKey: testManagedSSHKey}},
+			},
+			mockResponse: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+			},
+			user: &coderclient.CoderUser{
+				Email:    "test@example.com",
+				ID:       "user123",
+				Username: "testUser1",
+			},
+			expectErr:   false,
+			expectedIDs: []string{"123"},
+			expectedKey: testManagedSSHKey,
 		},
 	}
 
@@ -349,13 +988,44 @@ func TestSyncUser(t *testing.T) {
 
 			mockCoderClient := coderclient.NewCoderClient(server.URL, "test-token")
 
+			// A failed add is retried unless listing shows the key was added
+			// or Gerrit rejected the key.
+			addCalls := 1
+			if tc.mockGerrit.AddSSHKeyErr != nil && tc.mockGerrit.ListSSHKeysAfterAdd == nil && tc.mockGerrit.AddSSHKeyStatus == 0 {
+				addCalls = addSSHKeyAttempts
+			}
 			for _, gid := range tc.expectedIDs {
 				tc.mockGerrit.On("AddSSHKey", ctx, gid, tc.expectedKey).
-					Return(&gerrit.SSHKeyInfo{}, &gerrit.Response{}, tc.mockGerrit.AddSSHKeyErr).
+					Return(&gerrit.SSHKeyInfo{}, mockResponse(tc.mockGerrit.AddSSHKeyStatus), tc.mockGerrit.AddSSHKeyErr).
+					Times(addCalls)
+			}
+			for _, d := range tc.expectedDeletes {
+				gid, seq, _ := strings.Cut(d, "/")
+				tc.mockGerrit.On("DeleteSSHKey", ctx, gid, seq).
+					Return(mockResponse(tc.mockGerrit.DeleteSSHKeyStatus), tc.mockGerrit.DeleteSSHKeyErr).
+					Once()
+			}
+			for _, gid := range tc.expectedDeactivates {
+				tc.mockGerrit.On("DeleteActive", ctx, gid).
+					Return(mockResponse(tc.mockGerrit.DeleteActiveStatus), tc.mockGerrit.DeleteActiveErr).
+					Once()
+			}
+			for _, gid := range tc.expectedActivates {
+				tc.mockGerrit.On("SetActive", ctx, gid).
+					Return(&gerrit.Response{}, tc.mockGerrit.SetActiveErr).
 					Once()
 			}
 
-			err := syncUser(ctx, mockCoderClient, tc.mockGerrit, tc.user)
+			s := &syncer{
+				coder:          mockCoderClient,
+				gerrit:         tc.mockGerrit,
+				inactiveAction: tc.inactiveAction,
+				reactivate:     tc.reactivate,
+				minLastSeen:    tc.minLastSeen,
+				ambiguity:      tc.ambiguity,
+				policy:         tc.policy,
+			}
+			err := s.syncUser(ctx, tc.user)
 
 			if err == nil && tc.expectErr {
 				t.Errorf("Expected an error but got none")
@@ -365,11 +1035,273 @@ func TestSyncUser(t *testing.T) {
 				t.Errorf("Did not expect an error but got : %v", err)
 			}
 
-			tc.mockGerrit.AssertNumberOfCalls(t, "AddSSHKey", len(tc.expectedIDs))
+			tc.mockGerrit.AssertNumberOfCalls(t, "AddSSHKey", len(tc.expectedIDs)*addCalls)
 
 			for _, gid := range tc.expectedIDs {
 				tc.mockGerrit.AssertCalled(t, "AddSSHKey", ctx, gid, tc.expectedKey)
 			}
+
+			tc.mockGerrit.AssertNumberOfCalls(t, "DeleteSSHKey", len(tc.expectedDeletes))
+			tc.mockGerrit.AssertNumberOfCalls(t, "DeleteActive", len(tc.expectedDeactivates))
+			tc.mockGerrit.AssertNumberOfCalls(t, "SetActive", len(tc.expectedActivates))
+		})
+	}
+}
+
+func TestAccountQuery(t *testing.T) {
+	testCases := []struct {
+		inactiveAction inactiveAction
+		expected       string
+	}{
+		{inactiveAction: inactiveActionSkip, expected: `email:"test@example.com"`},
+		{inactiveAction: inactiveActionRevoke, expected: `email:"test@example.com"`},
+		{inactiveAction: inactiveActionDeactivate, expected: `email:"test@example.com" (is:active OR is:inactive)`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.inactiveAction), func(t *testing.T) {
+			g := &MockGerritClient{}
+			s := &syncer{gerrit: g, inactiveAction: tc.inactiveAction}
+			if _, err := s.findAccounts(context.Background(), &coderclient.CoderUser{Email: "test@example.com"}); err != nil {
+				t.Fatalf("findAccounts() error = %v", err)
+			}
+			if diff := cmp.Diff([]string{tc.expected}, g.Queries); diff != "" {
+				t.Errorf("queries mismatch (-want +got):\n%s", diff)
+			}
 		})
 	}
 }
+
+func TestParseInactiveAction(t *testing.T) {
+	testCases := []struct {
+		input     string
+		expected  inactiveAction
+		expectErr bool
+	}{
+		{input: "skip", expected: inactiveActionSkip},
+		{input: "revoke", expected: inactiveActionRevoke},
+		{input: "deactivate", expected: inactiveActionDeactivate},
+		{input: "delete", expectErr: true},
+		{input: "", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := parseInactiveAction(tc.input)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("parseInactiveAction(%q) error = %v, want error presence = %v", tc.input, err, tc.expectErr)
+			}
+			if got != tc.expected {
+				t.Errorf("parseInactiveAction(%q) = %q, want %q", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSyncUserDryRun(t *testing.T) {
+	ctx := context.Background()
+	testNormalizedSSHKey := generateTestSSHKey(t)
+	staleSSHKey := generateTestSSHKey(t) + " coder-gerrit-ssh-sync:user123"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"public_key": "%s"}`, testNormalizedSSHKey)
+	}))
+	defer server.Close()
+
+	mockGerrit := &MockGerritClient{
+		QueryResult: []gerrit.AccountInfo{
+			{AccountID: 123},
+			{AccountID: 456, Inactive: true},
+		},
+		ListSSHKeysResult: []gerrit.SSHKeyInfo{{Seq: 3, SSHPublicKey: staleSSHKey}},
+	}
+	s := &syncer{
+		coder:          coderclient.NewCoderClient(server.URL, "test-token"),
+		gerrit:         mockGerrit,
+		inactiveAction: inactiveActionDeactivate,
+		reactivate:     true,
+		dryRun:         true,
+		plan:           &plan{},
+	}
+	user := &coderclient.CoderUser{Email: "test@example.com", ID: "user123", Username: "testUser1"}
+
+	if err := s.syncUser(ctx, user); err != nil {
+		t.Fatalf("syncUser() error = %v", err)
+	}
+
+	mockGerrit.AssertNotCalled(t, "AddSSHKey", mock.Anything, mock.Anything, mock.Anything)
+	mockGerrit.AssertNotCalled(t, "DeleteSSHKey", mock.Anything, mock.Anything, mock.Anything)
+	mockGerrit.AssertNotCalled(t, "SetActive", mock.Anything, mock.Anything)
+
+	var got []string
+	for _, c := range s.plan.sorted() {
+		got = append(got, fmt.Sprintf("%d/%s", c.GerritAccountID, c.Action))
+	}
+	want := []string{"123/add", "123/remove", "456/activate", "456/add", "456/remove"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("planned changes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// concurrentGerrit is a gerritAccountsService that records the maximum number
+// of calls in flight on the same account.
+type concurrentGerrit struct {
+	mu          sync.Mutex
+	inFlight    map[string]int
+	maxInFlight int
+	added       []string
+}
+
+func (g *concurrentGerrit) enter(accountID string) func() {
+	g.mu.Lock()
+	if g.inFlight == nil {
+		g.inFlight = map[string]int{}
+	}
+	g.inFlight[accountID]++
+	g.maxInFlight = max(g.maxInFlight, g.inFlight[accountID])
+	g.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	return func() {
+		g.mu.Lock()
+		g.inFlight[accountID]--
+		g.mu.Unlock()
+	}
+}
+
+func (g *concurrentGerrit) QueryAccounts(ctx context.Context, opts *gerrit.QueryAccountOptions) (*[]gerrit.AccountInfo, *gerrit.Response, error) {
+	return &[]gerrit.AccountInfo{{AccountID: 123}}, &gerrit.Response{}, nil
+}
+
+func (g *concurrentGerrit) ListSSHKeys(ctx context.Context, accountID string) (*[]gerrit.SSHKeyInfo, *gerrit.Response, error) {
+	defer g.enter(accountID)()
+	return &[]gerrit.SSHKeyInfo{}, &gerrit.Response{}, nil
+}
+
+func (g *concurrentGerrit) AddSSHKey(ctx context.Context, accountID string, sshKey string) (*gerrit.SSHKeyInfo, *gerrit.Response, error) {
+	defer g.enter(accountID)()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.added = append(g.added, sshKey)
+	return &gerrit.SSHKeyInfo{}, &gerrit.Response{}, nil
+}
+
+func (g *concurrentGerrit) DeleteSSHKey(ctx context.Context, accountID, sshKeyID string) (*gerrit.Response, error) {
+	defer g.enter(accountID)()
+	return &gerrit.Response{}, nil
+}
+
+func (g *concurrentGerrit) DeleteActive(ctx context.Context, accountID string) (*gerrit.Response, error) {
+	defer g.enter(accountID)()
+	return &gerrit.Response{}, nil
+}
+
+func (g *concurrentGerrit) SetActive(ctx context.Context, accountID string) (*gerrit.Response, error) {
+	defer g.enter(accountID)()
+	return &gerrit.Response{}, nil
+}
+
+func (g *concurrentGerrit) GetAccount(ctx context.Context, account string) (*gerrit.AccountInfo, *gerrit.Response, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (g *concurrentGerrit) GetAccountDetails(ctx context.Context, accountID string) (*gerrit.AccountDetailInfo, *gerrit.Response, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (g *concurrentGerrit) GetAccountExternalIDs(ctx context.Context, accountID string) (*[]gerrit.AccountExternalIdInfo, *gerrit.Response, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (g *concurrentGerrit) ListAccountEmails(ctx context.Context, accountID string) (*[]gerrit.EmailInfo, *gerrit.Response, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func TestReconcileConcurrent(t *testing.T) {
+	ctx := context.Background()
+	testKey := generateTestSSHKey(t)
+
+	const numUsers = 20
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v2/users" {
+			var users []string
+			// Every user fits in the first page.
+			for i := range numUsers {
+				if r.URL.Query().Get("after_id") != "" {
+					break
+				}
+				users = append(users, fmt.Sprintf(`{"id": "id-%02d", "username": "user%02d", "email": "shared@example.com", "status": "active"}`, i, i))
+			}
+			fmt.Fprintf(w, `{"users": [%s], "count": %d}`, strings.Join(users, ","), numUsers)
+			return
+		}
+		fmt.Fprintf(w, `{"public_key": "%s"}`, testKey)
+	}))
+	defer server.Close()
+
+	g := &concurrentGerrit{}
+	s := &syncer{
+		coder:  coderclient.NewCoderClient(server.URL, "test-token"),
+		gerrit: g,
+		locks:  &accountLocks{},
+	}
+
+	if err := reconcile(ctx, ctx, &config{concurrency: 8}, s); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+
+	if len(g.added) != numUsers {
+		t.Errorf("added %d keys, want %d", len(g.added), numUsers)
+	}
+	if g.maxInFlight != 1 {
+		t.Errorf("up to %d calls were in flight on the same Gerrit account, want 1", g.maxInFlight)
+	}
+}
+
+func TestReconcileFailures(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/api/v2/users":
+			// No Coder user has a key.
+			fmt.Fprintln(w, `{}`)
+		case r.URL.Query().Get("after_id") == "":
+			fmt.Fprintln(w, `{"users": [{"id": "id-2", "username": "user2", "email": "user2@example.com"}, {"id": "id-1", "username": "user1", "email": "user1@example.com"}], "count": 2}`)
+		default:
+			fmt.Fprintln(w, `{"users": [], "count": 0}`)
+		}
+	}))
+	defer server.Close()
+
+	g := &concurrentGerrit{}
+	s := &syncer{coder: coderclient.NewCoderClient(server.URL, "test-token"), gerrit: g}
+	err := reconcile(ctx, ctx, &config{concurrency: 2}, s)
+	if err == nil {
+		t.Fatalf("reconcile() succeeded, want error")
+	}
+	want := "2 of 2 users failed\n" +
+		`sync user "user1 (id-1, user1@example.com, )": no SSH key found for user "user1 (id-1, user1@example.com, )"` + "\n" +
+		`sync user "user2 (id-2, user2@example.com, )": no SSH key found for user "user2 (id-2, user2@example.com, )"`
+	if diff := cmp.Diff(want, err.Error()); diff != "" {
+		t.Errorf("reconcile() error mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReconcileStopped(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"users": [{"id": "id-1", "username": "user1"}], "count": 1}`)
+	}))
+	defer server.Close()
+
+	stop, cancel := context.WithCancel(ctx)
+	cancel()
+
+	g := &concurrentGerrit{}
+	s := &syncer{coder: coderclient.NewCoderClient(server.URL, "test-token"), gerrit: g}
+	if err := reconcile(ctx, stop, &config{concurrency: 2}, s); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if len(g.added) != 0 {
+		t.Errorf("added %d keys after stop, want 0", len(g.added))
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// userMapping explicitly maps Coder users to Gerrit accounts, for users that
+// cannot be matched automatically, and lists Coder users that are never
+// synchronized.
+type userMapping struct {
+	// Users lists the mapped Coder users.
+	Users []userMappingEntry `json:"users" yaml:"users"`
+
+	// Exclude lists the IDs or usernames of Coder users that are never
+	// synchronized, such as service accounts.
+	Exclude []string `json:"exclude" yaml:"exclude"`
+}
+
+// userMappingEntry maps one Coder user to Gerrit accounts.
+type userMappingEntry struct {
+	// Coder is the ID or the username of the Coder user.
+	Coder string `json:"coder" yaml:"coder"`
+
+	// Gerrit lists the IDs of the Gerrit accounts of the Coder user.
+	Gerrit []int `json:"gerrit" yaml:"gerrit"`
+}
+
+// loadUserMapping reads a userMapping from the file path, which is YAML if its
+// extension is .yaml or .yml, and JSON otherwise. Unknown fields are rejected,
+// so that misspelled keys are not silently ignored.
+func loadUserMapping(path string) (*userMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m userMapping
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&m)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := m.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// check reports entries of m that are incomplete or contradict each other.
+func (m *userMapping) check() error {
+	var errs []error
+	mapped := map[string]bool{}
+	for i, e := range m.Users {
+		switch {
+		case e.Coder == "":
+			errs = append(errs, fmt.Errorf("users[%d]: no Coder user", i))
+		case mapped[e.Coder]:
+			errs = append(errs, fmt.Errorf("users[%d]: Coder user %q is mapped more than once", i, e.Coder))
+		case slices.Contains(m.Exclude, e.Coder):
+			errs = append(errs, fmt.Errorf("users[%d]: Coder user %q is both mapped and excluded", i, e.Coder))
+		}
+		mapped[e.Coder] = true
+
+		if len(e.Gerrit) == 0 {
+			errs = append(errs, fmt.Errorf("users[%d]: no Gerrit account for Coder user %q", i, e.Coder))
+		}
+		for _, id := range e.Gerrit {
+			if id <= 0 {
+				errs = append(errs, fmt.Errorf("users[%d]: invalid Gerrit account ID %d", i, id))
+			}
+		}
+	}
+	for i, c := range m.Exclude {
+		if c == "" {
+			errs = append(errs, fmt.Errorf("exclude[%d]: no Coder user", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkStrategies reports whether the users of m, if any, are matched before
+// any other strategy of strategies, so that the mapping overrides them instead
+// of being ignored or losing to an automatic match.
+func (m *userMapping) checkStrategies(strategies []matchStrategy) error {
+	if m == nil || len(m.Users) == 0 {
+		return nil
+	}
+	if len(strategies) == 0 || strategies[0] != matchMapping {
+		return fmt.Errorf("the mapped users require %s to be the first match strategy", matchMapping)
+	}
+	return nil
+}
+
+// resolve looks up every Gerrit account of m in svc, and reports the accounts
+// that cannot be found, so that mistyped IDs fail at startup instead of when
+// the Coder user is synchronized.
+func (m *userMapping) resolve(ctx context.Context, svc gerritAccountsService) error {
+	var errs []error
+	seen := map[int]bool{}
+	for _, e := range m.Users {
+		for _, id := range e.Gerrit {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if _, _, err := svc.GetAccount(ctx, strconv.Itoa(id)); err != nil {
+				errs = append(errs, fmt.Errorf("Gerrit account %d of Coder user %q: %w", id, e.Coder, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// accountsOf returns the Gerrit account IDs mapped to user. A nil
+// *userMapping maps nothing.
+func (m *userMapping) accountsOf(user *coderclient.CoderUser) []int {
+	if m == nil {
+		return nil
+	}
+	var ids []int
+	for _, e := range m.Users {
+		if e.Coder == user.ID || e.Coder == user.Username {
+			ids = append(ids, e.Gerrit...)
+		}
+	}
+	return ids
+}
+
+// excludes reports whether user is excluded from synchronization. A nil
+// *userMapping excludes nobody.
+func (m *userMapping) excludes(user *coderclient.CoderUser) bool {
+	if m == nil {
+		return false
+	}
+	return slices.Contains(m.Exclude, user.ID) || slices.Contains(m.Exclude, user.Username)
+}
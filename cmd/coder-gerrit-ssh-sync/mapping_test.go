@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+func TestLoadUserMapping(t *testing.T) {
+	dir := t.TempDir()
+	alice := &coderclient.CoderUser{ID: "id-alice", Username: "alice"}
+	bot := &coderclient.CoderUser{ID: "id-bot", Username: "ci-bot"}
+
+	testCases := []struct {
+		name            string
+		file            string
+		content         string
+		expectedAliceTo []int
+		expectErr       bool
+	}{
+		{
+			name:            "JSON",
+			file:            "mapping.json",
+			content:         `{"users": [{"coder": "alice", "gerrit": [1000, 1001]}, {"coder": "id-alice", "gerrit": [1002]}], "exclude": ["ci-bot"]}`,
+			expectedAliceTo: []int{1000, 1001, 1002},
+		},
+		{
+			name: "YAML",
+			file: "mapping.yaml",
+			content: `users:
+  - coder: alice
+    gerrit: [1000]
+exclude:
+  - id-bot
+`,
+			expectedAliceTo: []int{1000},
+		},
+		{
+			name:      "JSON_unknown_field",
+			file:      "unknown.json",
+			content:   `{"users": [{"coder": "alice", "gerit": [1000]}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "YAML_unknown_field",
+			file:      "unknown.yml",
+			content:   "users:\n  - coder: alice\n    gerrit: [1000]\nexclusions: [ci-bot]\n",
+			expectErr: true,
+		},
+		{
+			name:      "Invalid_account_ID",
+			file:      "invalid.json",
+			content:   `{"users": [{"coder": "alice", "gerrit": ["1000"]}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "Negative_account_ID",
+			file:      "negative.json",
+			content:   `{"users": [{"coder": "alice", "gerrit": [-1]}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "No_account",
+			file:      "empty.json",
+			content:   `{"users": [{"coder": "alice"}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "Mapped_twice",
+			file:      "twice.json",
+			content:   `{"users": [{"coder": "alice", "gerrit": [1000]}, {"coder": "alice", "gerrit": [1001]}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "Mapped_and_excluded",
+			file:      "both.json",
+			content:   `{"users": [{"coder": "alice", "gerrit": [1000]}], "exclude": ["alice"]}`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			m, err := loadUserMapping(path)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("loadUserMapping() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.expectedAliceTo, m.accountsOf(alice)); diff != "" {
+				t.Errorf("accountsOf() mismatch (-want +got):\n%s", diff)
+			}
+			if m.excludes(alice) || !m.excludes(bot) {
+				t.Errorf("excludes(alice) = %v, excludes(ci-bot) = %v, want false and true", m.excludes(alice), m.excludes(bot))
+			}
+		})
+	}
+
+	if _, err := loadUserMapping(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("loadUserMapping() of missing file succeeded, want error")
+	}
+
+	var nilMapping *userMapping
+	if got := nilMapping.accountsOf(alice); got != nil {
+		t.Errorf("nil mapping accountsOf() = %v, want nil", got)
+	}
+	if nilMapping.excludes(alice) {
+		t.Errorf("nil mapping excludes() = true, want false")
+	}
+}
+
+func TestUserMappingCheckStrategies(t *testing.T) {
+	mapped := &userMapping{Users: []userMappingEntry{{Coder: "alice", Gerrit: []int{1000}}}}
+	excludeOnly := &userMapping{Exclude: []string{"bot"}}
+
+	testCases := []struct {
+		name       string
+		mapping    *userMapping
+		strategies []matchStrategy
+		expectErr  bool
+	}{
+		{name: "No_mapping", strategies: []matchStrategy{matchEmail}},
+		{name: "Exclude_only", mapping: excludeOnly, strategies: []matchStrategy{matchEmail}},
+		{name: "Mapping_first", mapping: mapped, strategies: []matchStrategy{matchMapping, matchEmail}},
+		{name: "Mapping_after_email", mapping: mapped, strategies: []matchStrategy{matchEmail, matchMapping}, expectErr: true},
+		{name: "Mapping_missing", mapping: mapped, strategies: []matchStrategy{matchEmail}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.mapping.checkStrategies(tc.strategies)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Errorf("checkStrategies() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestUserMappingResolve(t *testing.T) {
+	ctx := context.Background()
+	g := &MockGerritClient{}
+	g.On("GetAccount", ctx, "1000").Return(&gerrit.AccountInfo{AccountID: 1000}, &gerrit.Response{}, nil)
+	g.On("GetAccount", ctx, "1001").Return((*gerrit.AccountInfo)(nil), (*gerrit.Response)(nil), errors.New("404 Not Found"))
+
+	m := &userMapping{Users: []userMappingEntry{
+		{Coder: "alice", Gerrit: []int{1000}},
+		{Coder: "id-alice", Gerrit: []int{1000}},
+		{Coder: "bob", Gerrit: []int{1001}},
+	}}
+	err := m.resolve(ctx, g)
+	if err == nil || !strings.Contains(err.Error(), `Gerrit account 1001 of Coder user "bob"`) {
+		t.Errorf("resolve() error = %v, want error for account 1001", err)
+	}
+	// Each account is looked up once.
+	g.AssertNumberOfCalls(t, "GetAccount", 2)
+}
+
+func TestSyncUserExcluded(t *testing.T) {
+	g := &MockGerritClient{QueryErr: errors.New("must not query Gerrit")}
+	s := &syncer{gerrit: g, mapping: &userMapping{Exclude: []string{"ci-bot"}}, plan: &plan{}}
+
+	if err := s.syncUser(context.Background(), &coderclient.CoderUser{ID: "id-bot", Username: "ci-bot", Email: "ci@example.com"}); err != nil {
+		t.Errorf("syncUser() error = %v", err)
+	}
+	if len(g.Queries) != 0 {
+		t.Errorf("queried Gerrit %q for an excluded user", g.Queries)
+	}
+}
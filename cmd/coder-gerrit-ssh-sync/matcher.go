@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// matchStrategy names a way of finding the Gerrit accounts of a Coder user.
+type matchStrategy string
+
+const (
+	// matchEmail matches Gerrit accounts that registered the Coder email.
+	matchEmail matchStrategy = "email"
+
+	// matchUsername matches the Gerrit account whose username is the Coder
+	// username.
+	matchUsername matchStrategy = "username"
+
+	// matchExternalID matches Gerrit accounts having an external ID with the
+	// OIDC subject of the Coder user. Only with prefetched accounts can it find
+	// accounts whose email and username both differ from the Coder ones;
+	// otherwise it only confirms accounts with the Coder email or username.
+	matchExternalID matchStrategy = "external-id"
+
+	// matchMapping matches the Gerrit accounts listed for the Coder user in
+	// the --mapping-file.
+	matchMapping matchStrategy = "mapping"
+)
+
+// parseMatchStrategies validates the values of the --match flag.
+func parseMatchStrategies(values []string) ([]matchStrategy, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no match strategy given")
+	}
+	var strategies []matchStrategy
+	seen := map[matchStrategy]bool{}
+	for _, v := range values {
+		switch st := matchStrategy(v); st {
+		case matchEmail, matchUsername, matchExternalID, matchMapping:
+			if seen[st] {
+				return nil, fmt.Errorf("match strategy %q given more than once", v)
+			}
+			seen[st] = true
+			strategies = append(strategies, st)
+		default:
+			return nil, fmt.Errorf("unknown match strategy %q", v)
+		}
+	}
+	return strategies, nil
+}
+
+// matcher finds the Gerrit accounts of Coder users with a single strategy.
+type matcher interface {
+	// strategy identifies the matcher in logs.
+	strategy() matchStrategy
+
+	// match returns the Gerrit accounts of user, or none if the strategy
+	// finds no account for user.
+	match(ctx context.Context, user *coderclient.CoderUser) ([]gerrit.AccountInfo, error)
+}
+
+// matchSources holds what matchers need to look up Gerrit accounts.
+type matchSources struct {
+	// gerrit is queried for accounts when accounts is nil.
+	gerrit gerritAccountsService
+
+	// accounts holds prefetched accounts. It may be nil.
+	accounts *gerritAccountIndex
+
+	// inactive makes queries return inactive accounts as well.
+	inactive bool
+
+	// coder is used to read the login type of Coder users.
+	coder *coderclient.CoderClient
+
+	// oidc describes the OIDC identities of Coder users in Gerrit.
+	oidc oidcIdentity
+
+	// mapping lists explicitly mapped Coder users. It may be nil.
+	mapping *userMapping
+
+	// emails normalizes the emails compared by emailMatcher.
+	emails emailNormalizer
+
+	// verifiedEmails makes emailMatcher ignore Gerrit accounts whose matching
+	// email is not confirmed.
+	verifiedEmails bool
+}
+
+// newMatchers returns the matchers of strategies, in the same order.
+func newMatchers(strategies []matchStrategy, src matchSources) []matcher {
+	var matchers []matcher
+	for _, st := range strategies {
+		switch st {
+		case matchEmail:
+			matchers = append(matchers, &emailMatcher{src: src})
+		case matchUsername:
+			matchers = append(matchers, &usernameMatcher{src: src})
+		case matchExternalID:
+			matchers = append(matchers, &externalIDMatcher{src: src})
+		case matchMapping:
+			matchers = append(matchers, &mappingMatcher{src: src})
+		}
+	}
+	return matchers
+}
+
+// queryAccounts returns the Gerrit accounts matching predicate. Gerrit only
+// returns active accounts unless the query asks for inactive ones.
+func (src matchSources) queryAccounts(ctx context.Context, predicate string) ([]gerrit.AccountInfo, error) {
+	query := predicate
+	if src.inactive {
+		query += " (is:active OR is:inactive)"
+	}
+	gus, _, err := src.gerrit.QueryAccounts(ctx, &gerrit.QueryAccountOptions{
+		QueryOptions: gerrit.QueryOptions{
+			Query: []string{query},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return *gus, nil
+}
+
+// emailMatcher implements matchEmail.
+type emailMatcher struct {
+	src matchSources
+}
+
+func (m *emailMatcher) strategy() matchStrategy { return matchEmail }
+
+func (m *emailMatcher) match(ctx context.Context, user *coderclient.CoderUser) ([]gerrit.AccountInfo, error) {
+	if user.Email == "" {
+		return nil, nil
+	}
+	var gus []gerrit.AccountInfo
+	if m.src.accounts != nil {
+		gus = m.src.accounts.lookupEmail(user.Email)
+	} else {
+		// Make API call to search gerrit account using email, under every
+		// address it may have been registered with.
+		seen := map[int]bool{}
+		for _, email := range m.src.emails.variants(user.Email) {
+			found, err := m.src.queryAccounts(ctx, fmt.Sprintf("email:%q", email))
+			if err != nil {
+				return nil, err
+			}
+			for _, gu := range found {
+				if !seen[gu.AccountID] {
+					seen[gu.AccountID] = true
+					gus = append(gus, gu)
+				}
+			}
+		}
+	}
+	if !m.src.verifiedEmails {
+		return gus, nil
+	}
+	return m.verified(ctx, user, gus)
+}
+
+// verified returns the accounts of gus having confirmed an email equal to the
+// email of user.
+func (m *emailMatcher) verified(ctx context.Context, user *coderclient.CoderUser, gus []gerrit.AccountInfo) ([]gerrit.AccountInfo, error) {
+	var verified []gerrit.AccountInfo
+	for _, gu := range gus {
+		infos, _, err := m.src.gerrit.ListAccountEmails(ctx, strconv.Itoa(gu.AccountID))
+		if err != nil {
+			return nil, fmt.Errorf("list emails of Gerrit user %d: %w", gu.AccountID, err)
+		}
+		confirmed := slices.ContainsFunc(*infos, func(info gerrit.EmailInfo) bool {
+			return !info.PendingConfirmation && m.src.emails.equal(info.Email, user.Email)
+		})
+		if !confirmed {
+			log.Printf("Ignoring Gerrit user %d of Coder user %q: email not verified", gu.AccountID, user)
+			continue
+		}
+		verified = append(verified, gu)
+	}
+	return verified, nil
+}
+
+// usernameMatcher implements matchUsername.
+type usernameMatcher struct {
+	src matchSources
+}
+
+func (m *usernameMatcher) strategy() matchStrategy { return matchUsername }
+
+func (m *usernameMatcher) match(ctx context.Context, user *coderclient.CoderUser) ([]gerrit.AccountInfo, error) {
+	if user.Username == "" {
+		return nil, nil
+	}
+	if m.src.accounts != nil {
+		return m.src.accounts.lookupUsername(user.Username), nil
+	}
+	return m.src.queryAccounts(ctx, fmt.Sprintf("username:%q", user.Username))
+}
+
+// externalIDMatcher implements matchExternalID.
+//
+// Coder users logging in with OIDC are matched to the Gerrit accounts having
+// an external ID of one of src.oidc.schemes that holds the OIDC subject of the
+// user. With prefetched accounts indexed by OIDC subject, every account is
+// considered. Otherwise, since Gerrit cannot search accounts by external ID,
+// only the accounts matching the Coder email or username are checked: the
+// strategy then confirms such matches, but cannot find other accounts.
+type externalIDMatcher struct {
+	src matchSources
+}
+
+func (m *externalIDMatcher) strategy() matchStrategy { return matchExternalID }
+
+func (m *externalIDMatcher) match(ctx context.Context, user *coderclient.CoderUser) ([]gerrit.AccountInfo, error) {
+	loginType := user.LoginType
+	if loginType == "" {
+		// Some Coder versions omit the login type from listed users.
+		var err error
+		if loginType, err = m.src.coder.GetUserLoginType(ctx, user.ID); err != nil {
+			return nil, fmt.Errorf("get login type of Coder user %q: %w", user, err)
+		}
+	}
+	if loginType != coderclient.LoginTypeOIDC {
+		return nil, nil
+	}
+	subject := m.src.oidc.subject(user)
+	if subject == "" {
+		return nil, nil
+	}
+
+	if m.src.accounts != nil && m.src.accounts.bySubject != nil {
+		return m.src.accounts.lookupSubject(subject), nil
+	}
+
+	candidates, err := m.candidates(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []gerrit.AccountInfo
+	for _, gu := range candidates {
+		infos, _, err := m.src.gerrit.GetAccountExternalIDs(ctx, strconv.Itoa(gu.AccountID))
+		if err != nil {
+			return nil, fmt.Errorf("get external IDs of Gerrit user %d: %w", gu.AccountID, err)
+		}
+		for _, info := range *infos {
+			if m.src.oidc.matches(info.Identity, subject) {
+				matched = append(matched, gu)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+// candidates returns the Gerrit accounts matching the email or the username of
+// user, once each.
+func (m *externalIDMatcher) candidates(ctx context.Context, user *coderclient.CoderUser) ([]gerrit.AccountInfo, error) {
+	var gus []gerrit.AccountInfo
+	if m.src.accounts != nil {
+		gus = append(m.src.accounts.lookupEmail(user.Email), m.src.accounts.lookupUsername(user.Username)...)
+	} else {
+		for _, predicate := range []string{fmt.Sprintf("email:%q", user.Email), fmt.Sprintf("username:%q", user.Username)} {
+			found, err := m.src.queryAccounts(ctx, predicate)
+			if err != nil {
+				return nil, err
+			}
+			gus = append(gus, found...)
+		}
+	}
+
+	var candidates []gerrit.AccountInfo
+	seen := map[int]bool{}
+	for _, gu := range gus {
+		if !seen[gu.AccountID] {
+			seen[gu.AccountID] = true
+			candidates = append(candidates, gu)
+		}
+	}
+	return candidates, nil
+}
+
+// oidcField names the Coder user field holding the OIDC subject.
+type oidcField string
+
+const (
+	// oidcFieldUsername is the Coder username, filled by Coder from the
+	// claim named by its --oidc-username-field.
+	oidcFieldUsername oidcField = "username"
+
+	// oidcFieldEmail is the Coder email, filled by Coder from the claim named
+	// by its --oidc-email-field.
+	oidcFieldEmail oidcField = "email"
+)
+
+// oidcIdentity describes how the OIDC identity of Coder users appears in
+// Gerrit external IDs.
+type oidcIdentity struct {
+	// subjectField is the Coder user field holding the OIDC subject.
+	subjectField oidcField
+
+	// schemes lists the schemes of Gerrit external IDs created by OIDC logins,
+	// such as "oauth" or "openid".
+	schemes []string
+}
+
+// parseOIDCIdentity validates the values of the --oidc-subject-field and
+// --oidc-external-id-schemes flags.
+func parseOIDCIdentity(field string, schemes []string) (oidcIdentity, error) {
+	switch f := oidcField(field); f {
+	case oidcFieldUsername, oidcFieldEmail:
+	default:
+		return oidcIdentity{}, fmt.Errorf("unknown OIDC subject field %q", field)
+	}
+	if len(schemes) == 0 {
+		return oidcIdentity{}, fmt.Errorf("no external ID scheme given")
+	}
+	for _, scheme := range schemes {
+		if scheme == "" || strings.Contains(scheme, ":") {
+			return oidcIdentity{}, fmt.Errorf("invalid external ID scheme %q", scheme)
+		}
+	}
+	return oidcIdentity{subjectField: oidcField(field), schemes: schemes}, nil
+}
+
+// subject returns the OIDC subject of user.
+func (o oidcIdentity) subject(user *coderclient.CoderUser) string {
+	if o.subjectField == oidcFieldEmail {
+		return user.Email
+	}
+	return user.Username
+}
+
+// matches reports whether the Gerrit external ID extID is an OIDC identity of
+// subject. Depending on the Gerrit plugin, the subject follows the scheme
+// directly, as in "openid:<subject>", or after the provider, as in
+// "oauth:<provider>:<subject>".
+func (o oidcIdentity) matches(extID, subject string) bool {
+	return slices.Contains(o.subjects(extID), subject)
+}
+
+// subjects returns every subject the Gerrit external ID extID is an OIDC
+// identity of, as defined by matches: its key after the scheme, and each
+// suffix of the key following a ":" or a "/".
+func (o oidcIdentity) subjects(extID string) []string {
+	scheme, key, ok := strings.Cut(extID, ":")
+	if !ok || !slices.Contains(o.schemes, scheme) || key == "" {
+		return nil
+	}
+	subjects := []string{key}
+	for i, r := range key {
+		if (r == ':' || r == '/') && i+1 < len(key) {
+			subjects = append(subjects, key[i+1:])
+		}
+	}
+	return subjects
+}
+
+// mappingMatcher implements matchMapping.
+type mappingMatcher struct {
+	src matchSources
+}
+
+func (m *mappingMatcher) strategy() matchStrategy { return matchMapping }
+
+func (m *mappingMatcher) match(ctx context.Context, user *coderclient.CoderUser) ([]gerrit.AccountInfo, error) {
+	var gus []gerrit.AccountInfo
+	for _, accountID := range m.src.mapping.accountsOf(user) {
+		gu, _, err := m.src.gerrit.GetAccount(ctx, strconv.Itoa(accountID))
+		if err != nil {
+			return nil, fmt.Errorf("get mapped Gerrit user %d: %w", accountID, err)
+		}
+		gus = append(gus, *gu)
+	}
+	return gus, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// queryGerrit serves QueryAccounts from results keyed by query string.
+type queryGerrit struct {
+	*MockGerritClient
+	results map[string][]gerrit.AccountInfo
+	queries []string
+}
+
+func (g *queryGerrit) QueryAccounts(ctx context.Context, opts *gerrit.QueryAccountOptions) (*[]gerrit.AccountInfo, *gerrit.Response, error) {
+	q := opts.Query[0]
+	g.queries = append(g.queries, q)
+	result := g.results[q]
+	return &result, &gerrit.Response{}, nil
+}
+
+func TestParseMatchStrategies(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     []string
+		expected  []matchStrategy
+		expectErr bool
+	}{
+		{name: "Default", input: []string{"email"}, expected: []matchStrategy{matchEmail}},
+		{
+			name:     "Chain",
+			input:    []string{"mapping", "external-id", "email", "username"},
+			expected: []matchStrategy{matchMapping, matchExternalID, matchEmail, matchUsername},
+		},
+		{name: "Empty", input: nil, expectErr: true},
+		{name: "Unknown", input: []string{"email", "name"}, expectErr: true},
+		{name: "Duplicate", input: []string{"email", "email"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseMatchStrategies(tc.input)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("parseMatchStrategies(%q) error = %v, want error presence = %v", tc.input, err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("parseMatchStrategies(%q) mismatch (-want +got):\n%s", tc.input, diff)
+			}
+		})
+	}
+}
+
+func TestFindAccountsChain(t *testing.T) {
+	ctx := context.Background()
+	user := &coderclient.CoderUser{ID: "id-alice", Username: "alice", Email: "alice@corp.example.com"}
+
+	testCases := []struct {
+		name            string
+		strategies      []matchStrategy
+		results         map[string][]gerrit.AccountInfo
+		expectedIDs     []int
+		expectedQueries []string
+	}{
+		{
+			name:       "Email_first",
+			strategies: []matchStrategy{matchEmail, matchUsername},
+			results: map[string][]gerrit.AccountInfo{
+				`email:"alice@corp.example.com"`: {{AccountID: 1}},
+				`username:"alice"`:               {{AccountID: 2}},
+			},
+			expectedIDs:     []int{1},
+			expectedQueries: []string{`email:"alice@corp.example.com"`},
+		},
+		{
+			name:       "Fallback_to_username",
+			strategies: []matchStrategy{matchEmail, matchUsername},
+			results: map[string][]gerrit.AccountInfo{
+				`username:"alice"`: {{AccountID: 2}},
+			},
+			expectedIDs:     []int{2},
+			expectedQueries: []string{`email:"alice@corp.example.com"`, `username:"alice"`},
+		},
+		{
+			name:            "No_match",
+			strategies:      []matchStrategy{matchUsername, matchEmail},
+			results:         map[string][]gerrit.AccountInfo{},
+			expectedQueries: []string{`username:"alice"`, `email:"alice@corp.example.com"`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &queryGerrit{MockGerritClient: &MockGerritClient{}, results: tc.results}
+			s := &syncer{
+				gerrit:   g,
+				matchers: newMatchers(tc.strategies, matchSources{gerrit: g}),
+			}
+
+			gus, err := s.findAccounts(ctx, user)
+			if err != nil {
+				t.Fatalf("findAccounts() error = %v", err)
+			}
+			var gotIDs []int
+			for _, gu := range gus {
+				gotIDs = append(gotIDs, gu.AccountID)
+			}
+			if diff := cmp.Diff(tc.expectedIDs, gotIDs); diff != "" {
+				t.Errorf("account IDs mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expectedQueries, g.queries); diff != "" {
+				t.Errorf("queries mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFindAccountsError(t *testing.T) {
+	g := &MockGerritClient{QueryErr: errors.New("Gerrit unavailable")}
+	s := &syncer{
+		gerrit:   g,
+		matchers: newMatchers([]matchStrategy{matchUsername}, matchSources{gerrit: g}),
+	}
+	if _, err := s.findAccounts(context.Background(), &coderclient.CoderUser{Username: "alice"}); err == nil {
+		t.Errorf("findAccounts() succeeded, want error")
+	}
+}
+
+func TestExternalIDMatcher(t *testing.T) {
+	ctx := context.Background()
+	oidc := oidcIdentity{subjectField: oidcFieldUsername, schemes: []string{"oauth", "openid"}}
+	user := &coderclient.CoderUser{ID: "id-alice", Username: "alice", Email: "alice@example.com", LoginType: coderclient.LoginTypeOIDC}
+
+	newGerrit := func() *queryGerrit {
+		g := &queryGerrit{
+			MockGerritClient: &MockGerritClient{},
+			results: map[string][]gerrit.AccountInfo{
+				`email:"alice@example.com"`: {{AccountID: 1}, {AccountID: 2}},
+				`username:"alice"`:          {{AccountID: 2}},
+			},
+		}
+		g.On("GetAccountExternalIDs", ctx, "1").Return(&[]gerrit.AccountExternalIdInfo{{Identity: "gerrit:alice"}, {Identity: "mailto:alice@example.com"}}, &gerrit.Response{}, nil)
+		g.On("GetAccountExternalIDs", ctx, "2").Return(&[]gerrit.AccountExternalIdInfo{{Identity: "oauth:corp-oidc:alice"}}, &gerrit.Response{}, nil)
+		g.On("GetAccountExternalIDs", ctx, "3").Return(&[]gerrit.AccountExternalIdInfo{{Identity: "openid:alice"}}, &gerrit.Response{}, nil)
+		g.On("GetAccountExternalIDs", ctx, "4").Return(&[]gerrit.AccountExternalIdInfo{{Identity: "oauth:corp-oidc:bob"}}, &gerrit.Response{}, nil)
+		return g
+	}
+
+	t.Run("Candidates", func(t *testing.T) {
+		g := newGerrit()
+		m := &externalIDMatcher{src: matchSources{gerrit: g, oidc: oidc}}
+		gus, err := m.match(ctx, user)
+		if err != nil {
+			t.Fatalf("match() error = %v", err)
+		}
+		if len(gus) != 1 || gus[0].AccountID != 2 {
+			t.Errorf("match() = %+v, want account 2", gus)
+		}
+		// Account 2 is a candidate twice, but is only checked once.
+		g.AssertNumberOfCalls(t, "GetAccountExternalIDs", 2)
+	})
+
+	t.Run("Prefetched", func(t *testing.T) {
+		g := newGerrit()
+		idx := newGerritAccountIndex(emailNormalizer{})
+		idx.add(gerrit.AccountInfo{AccountID: 1, Email: "alice@example.com"})
+		idx.add(gerrit.AccountInfo{AccountID: 2, Username: "alice"})
+		idx.add(gerrit.AccountInfo{AccountID: 3, Username: "bob"})
+		m := &externalIDMatcher{src: matchSources{gerrit: g, accounts: idx, oidc: oidc}}
+		gus, err := m.match(ctx, user)
+		if err != nil {
+			t.Fatalf("match() error = %v", err)
+		}
+		if len(gus) != 1 || gus[0].AccountID != 2 {
+			t.Errorf("match() = %+v, want account 2", gus)
+		}
+		// Only the candidates are checked, not every prefetched account.
+		g.AssertNumberOfCalls(t, "GetAccountExternalIDs", 2)
+		if len(g.queries) != 0 {
+			t.Errorf("queried Gerrit %q with prefetched accounts", g.queries)
+		}
+	})
+
+	t.Run("Indexed_external_IDs", func(t *testing.T) {
+		g := newGerrit()
+		idx := newGerritAccountIndex(emailNormalizer{})
+		idx.add(gerrit.AccountInfo{AccountID: 1, Email: "alice@example.com"})
+		idx.add(gerrit.AccountInfo{AccountID: 2, Username: "alice"})
+		// Neither the email nor the username of account 3 is the Coder one.
+		idx.add(gerrit.AccountInfo{AccountID: 3, Username: "a.smith", Email: "a.smith@corp.example"})
+		idx.add(gerrit.AccountInfo{AccountID: 4, Username: "bob"})
+		if err := idx.indexExternalIDs(ctx, g, oidc); err != nil {
+			t.Fatalf("indexExternalIDs() error = %v", err)
+		}
+		g.AssertNumberOfCalls(t, "GetAccountExternalIDs", 4)
+
+		m := &externalIDMatcher{src: matchSources{gerrit: g, accounts: idx, oidc: oidc}}
+		gus, err := m.match(ctx, user)
+		if err != nil {
+			t.Fatalf("match() error = %v", err)
+		}
+		var ids []int
+		for _, gu := range gus {
+			ids = append(ids, gu.AccountID)
+		}
+		if diff := cmp.Diff([]int{2, 3}, ids); diff != "" {
+			t.Errorf("match() account IDs mismatch (-want +got):\n%s", diff)
+		}
+		// Matching uses the index only.
+		g.AssertNumberOfCalls(t, "GetAccountExternalIDs", 4)
+	})
+
+	t.Run("Not_OIDC", func(t *testing.T) {
+		g := newGerrit()
+		m := &externalIDMatcher{src: matchSources{gerrit: g, oidc: oidc}}
+		gus, err := m.match(ctx, &coderclient.CoderUser{ID: "id-alice", Username: "alice", LoginType: coderclient.LoginTypePassword})
+		if err != nil || len(gus) != 0 {
+			t.Errorf("match() = %+v, %v, want no account", gus, err)
+		}
+		g.AssertNotCalled(t, "GetAccountExternalIDs")
+	})
+
+	t.Run("Login_type_from_Coder", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v2/users/id-alice/login-type" {
+				t.Errorf("unexpected path %q", r.URL.Path)
+			}
+			fmt.Fprintln(w, `{"login_type": "oidc"}`)
+		}))
+		defer server.Close()
+
+		g := newGerrit()
+		m := &externalIDMatcher{src: matchSources{coder: coderclient.NewCoderClient(server.URL, "test-token"), gerrit: g, oidc: oidc}}
+		gus, err := m.match(ctx, &coderclient.CoderUser{ID: "id-alice", Username: "alice", Email: "alice@example.com"})
+		if err != nil {
+			t.Fatalf("match() error = %v", err)
+		}
+		if len(gus) != 1 || gus[0].AccountID != 2 {
+			t.Errorf("match() = %+v, want account 2", gus)
+		}
+	})
+}
+
+func TestOIDCIdentityMatches(t *testing.T) {
+	oidc := oidcIdentity{subjectField: oidcFieldUsername, schemes: []string{"oauth", "openid"}}
+	testCases := []struct {
+		extID    string
+		expected bool
+	}{
+		{extID: "oauth:alice", expected: true},
+		{extID: "oauth:corp-oidc:alice", expected: true},
+		{extID: "openid:https://sso.example.com/alice", expected: true},
+		{extID: "gerrit:alice", expected: false},
+		{extID: "oauth:corp-oidc:malice", expected: false},
+		{extID: "oauth:alice:other", expected: false},
+		{extID: "alice", expected: false},
+	}
+
+	for _, tc := range testCases {
+		if got := oidc.matches(tc.extID, "alice"); got != tc.expected {
+			t.Errorf("matches(%q, %q) = %v, want %v", tc.extID, "alice", got, tc.expected)
+		}
+	}
+}
+
+func TestParseOIDCIdentity(t *testing.T) {
+	testCases := []struct {
+		name      string
+		field     string
+		schemes   []string
+		expectErr bool
+	}{
+		{name: "Username", field: "username", schemes: []string{"oauth"}},
+		{name: "Email", field: "email", schemes: []string{"oauth", "openid"}},
+		{name: "Unknown_field", field: "name", schemes: []string{"oauth"}, expectErr: true},
+		{name: "No_scheme", field: "username", expectErr: true},
+		{name: "Invalid_scheme", field: "username", schemes: []string{"oauth:"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseOIDCIdentity(tc.field, tc.schemes)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Errorf("parseOIDCIdentity(%q, %q) error = %v, want error presence = %v", tc.field, tc.schemes, err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestMappingMatcher(t *testing.T) {
+	ctx := context.Background()
+	g := &MockGerritClient{}
+	g.On("GetAccount", ctx, "1000").Return(&gerrit.AccountInfo{AccountID: 1000}, &gerrit.Response{}, nil)
+	g.On("GetAccount", ctx, "2000").Return((*gerrit.AccountInfo)(nil), (*gerrit.Response)(nil), errors.New("not found"))
+
+	m := &mappingMatcher{src: matchSources{gerrit: g, mapping: &userMapping{Users: []userMappingEntry{
+		{Coder: "alice", Gerrit: []int{1000}},
+		{Coder: "id-bob", Gerrit: []int{2000}},
+	}}}}
+
+	gus, err := m.match(ctx, &coderclient.CoderUser{ID: "id-alice", Username: "alice"})
+	if err != nil || len(gus) != 1 || gus[0].AccountID != 1000 {
+		t.Errorf("match(alice) = %+v, %v, want account 1000", gus, err)
+	}
+
+	if _, err := m.match(ctx, &coderclient.CoderUser{ID: "id-bob", Username: "bob"}); err == nil {
+		t.Errorf("match(bob) succeeded, want error")
+	}
+
+	gus, err = m.match(ctx, &coderclient.CoderUser{ID: "id-carol", Username: "carol"})
+	if err != nil || len(gus) != 0 {
+		t.Errorf("match(carol) = %+v, %v, want no account", gus, err)
+	}
+	// Only the accounts mapped to alice and bob are looked up.
+	g.AssertNumberOfCalls(t, "GetAccount", 2)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// planAction is the kind of change made, or intended, on a Gerrit account.
+type planAction string
+
+const (
+	planActionAdd        planAction = "add"
+	planActionSkip       planAction = "skip"
+	planActionRemove     planAction = "remove"
+	planActionDeactivate planAction = "deactivate"
+	planActionActivate   planAction = "activate"
+)
+
+// outputFormat selects how a plan is printed.
+type outputFormat string
+
+const (
+	outputFormatTable outputFormat = "table"
+	outputFormatJSON  outputFormat = "json"
+)
+
+// parseOutputFormat validates the value of the --output flag.
+func parseOutputFormat(s string) (outputFormat, error) {
+	switch f := outputFormat(s); f {
+	case outputFormatTable, outputFormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q", s)
+	}
+}
+
+// plannedChange is a single change on a Gerrit account on behalf of a Coder
+// user. GerritAccountID is zero for changes that concern the Coder user only,
+// such as skipping a user without any matching Gerrit account.
+type plannedChange struct {
+	CoderUserID     string     `json:"coder_user_id"`
+	CoderUsername   string     `json:"coder_username"`
+	CoderEmail      string     `json:"coder_email"`
+	GerritAccountID int        `json:"gerrit_account_id,omitempty"`
+	Action          planAction `json:"action"`
+	Key             string     `json:"key,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+}
+
+// newPlannedChange returns a change of action on the Gerrit account accountID
+// made on behalf of user.
+func newPlannedChange(user *coderclient.CoderUser, accountID int, action planAction, reason string) plannedChange {
+	return plannedChange{
+		CoderUserID:     user.ID,
+		CoderUsername:   user.Username,
+		CoderEmail:      user.Email,
+		GerritAccountID: accountID,
+		Action:          action,
+		Reason:          reason,
+	}
+}
+
+// plan collects the changes of a reconciliation pass. It is safe for
+// concurrent use, and a nil *plan discards everything recorded.
+type plan struct {
+	mu      sync.Mutex
+	changes []plannedChange
+}
+
+// record adds change to p.
+func (p *plan) record(change plannedChange) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.changes = append(p.changes, change)
+}
+
+// sorted returns the recorded changes ordered by Coder user and Gerrit
+// account, keeping the order of changes within the same account.
+func (p *plan) sorted() []plannedChange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	changes := slices.Clone(p.changes)
+	slices.SortStableFunc(changes, func(a, b plannedChange) int {
+		return cmp.Or(
+			cmp.Compare(a.CoderUsername, b.CoderUsername),
+			cmp.Compare(a.CoderUserID, b.CoderUserID),
+			cmp.Compare(a.GerritAccountID, b.GerritAccountID),
+		)
+	})
+	return changes
+}
+
+// summary counts the recorded changes per action.
+func (p *plan) summary() map[planAction]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counts := map[planAction]int{}
+	for _, c := range p.changes {
+		counts[c.Action]++
+	}
+	return counts
+}
+
+// write prints p to w in format.
+func (p *plan) write(w io.Writer, format outputFormat) error {
+	if format == outputFormatJSON {
+		return p.writeJSON(w)
+	}
+	return p.writeTable(w)
+}
+
+// writeJSON prints p to w as a JSON document.
+func (p *plan) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Changes []plannedChange    `json:"changes"`
+		Summary map[planAction]int `json:"summary"`
+	}{
+		Changes: p.sorted(),
+		Summary: p.summary(),
+	})
+}
+
+// writeTable prints p to w as a human readable table followed by a summary.
+func (p *plan) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODER USER\tEMAIL\tGERRIT ACCOUNT\tACTION\tDETAILS")
+	for _, c := range p.sorted() {
+		account := "-"
+		if c.GerritAccountID != 0 {
+			account = strconv.Itoa(c.GerritAccountID)
+		}
+		details := c.Reason
+		if c.Key != "" {
+			details = c.Key
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.CoderUsername, c.CoderEmail, account, c.Action, details)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	counts := p.summary()
+	_, err := fmt.Fprintf(w, "\n%d to add, %d to remove, %d to deactivate, %d to activate, %d skipped.\n",
+		counts[planActionAdd], counts[planActionRemove], counts[planActionDeactivate], counts[planActionActivate], counts[planActionSkip])
+	return err
+}
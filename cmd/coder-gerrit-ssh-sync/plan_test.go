@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+func testPlan() *plan {
+	alice := &coderclient.CoderUser{ID: "id-alice", Username: "alice", Email: "alice@example.com"}
+	bob := &coderclient.CoderUser{ID: "id-bob", Username: "bob", Email: "bob@example.com"}
+
+	p := &plan{}
+	p.record(newPlannedChange(bob, 0, planActionSkip, "no matching Gerrit account"))
+	add := newPlannedChange(alice, 456, planActionAdd, "")
+	add.Key = "ssh-ed25519 AAAA coder-gerrit-ssh-sync:id-alice"
+	p.record(add)
+	p.record(newPlannedChange(alice, 123, planActionSkip, "SSH key already present"))
+	remove := newPlannedChange(alice, 456, planActionRemove, "stale Coder-managed SSH key")
+	remove.Key = "ssh-ed25519 BBBB coder-gerrit-ssh-sync:id-alice"
+	p.record(remove)
+	return p
+}
+
+func TestPlanWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testPlan().write(&buf, outputFormatJSON); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	var got struct {
+		Changes []plannedChange    `json:"changes"`
+		Summary map[planAction]int `json:"summary"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+
+	var gotOrder []string
+	for _, c := range got.Changes {
+		gotOrder = append(gotOrder, c.CoderUsername+"/"+string(c.Action))
+	}
+	wantOrder := []string{"alice/skip", "alice/add", "alice/remove", "bob/skip"}
+	if diff := cmp.Diff(wantOrder, gotOrder); diff != "" {
+		t.Errorf("change order mismatch (-want +got):\n%s", diff)
+	}
+
+	wantSummary := map[planAction]int{planActionAdd: 1, planActionRemove: 1, planActionSkip: 2}
+	if diff := cmp.Diff(wantSummary, got.Summary); diff != "" {
+		t.Errorf("summary mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPlanWriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testPlan().write(&buf, outputFormatTable); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"CODER USER",
+		"ssh-ed25519 AAAA coder-gerrit-ssh-sync:id-alice",
+		"no matching Gerrit account",
+		"1 to add, 1 to remove, 0 to deactivate, 0 to activate, 2 skipped.",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestPlanNil(t *testing.T) {
+	var p *plan
+	p.record(plannedChange{Action: planActionAdd})
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	for _, input := range []string{"table", "json"} {
+		if got, err := parseOutputFormat(input); err != nil || string(got) != input {
+			t.Errorf("parseOutputFormat(%q) = %q, %v", input, got, err)
+		}
+	}
+	if _, err := parseOutputFormat("yaml"); err == nil {
+		t.Errorf("parseOutputFormat(%q) succeeded, want error", "yaml")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// userPolicy selects the Coder users synchronized by their login type and
+// roles, so that bots and privileged accounts do not get Gerrit keys. The zero
+// value selects every user.
+type userPolicy struct {
+	// loginTypes, if not empty, lists the only login types of synchronized
+	// users.
+	loginTypes []coderclient.LoginType
+
+	// skipRoles lists site-wide roles whose holders are never synchronized.
+	skipRoles []string
+
+	// skipServiceAccounts skips users without login, with LoginTypeNone,
+	// which Coder uses for service accounts.
+	skipServiceAccounts bool
+}
+
+// parseUserPolicy validates the values of the --only-login-type, --skip-role
+// and --skip-service-accounts flags.
+func parseUserPolicy(loginTypes, skipRoles []string, skipServiceAccounts bool) (userPolicy, error) {
+	p := userPolicy{skipServiceAccounts: skipServiceAccounts}
+	for _, s := range loginTypes {
+		switch lt := coderclient.LoginType(s); lt {
+		case coderclient.LoginTypePassword, coderclient.LoginTypeGithub, coderclient.LoginTypeOIDC, coderclient.LoginTypeToken, coderclient.LoginTypeNone:
+			p.loginTypes = append(p.loginTypes, lt)
+		default:
+			return userPolicy{}, fmt.Errorf("unknown login type %q", s)
+		}
+	}
+	if skipServiceAccounts && slices.Contains(p.loginTypes, coderclient.LoginTypeNone) {
+		return userPolicy{}, fmt.Errorf("login type %q is both selected and skipped as service accounts", coderclient.LoginTypeNone)
+	}
+	for _, role := range skipRoles {
+		if role == "" {
+			return userPolicy{}, fmt.Errorf("empty role")
+		}
+	}
+	p.skipRoles = skipRoles
+	return p, nil
+}
+
+// reject returns why user is not synchronized, or "" if it is. The login type
+// of user is read from c if Coder omitted it from the user, and kept in user.
+func (p userPolicy) reject(ctx context.Context, c *coderclient.CoderClient, user *coderclient.CoderUser) (string, error) {
+	for _, role := range p.skipRoles {
+		if user.HasRole(role) {
+			return fmt.Sprintf("Coder role %s", role), nil
+		}
+	}
+	if len(p.loginTypes) == 0 && !p.skipServiceAccounts {
+		return "", nil
+	}
+	if user.LoginType == "" {
+		// Some Coder versions omit the login type from listed users.
+		loginType, err := c.GetUserLoginType(ctx, user.ID)
+		if err != nil {
+			return "", fmt.Errorf("get login type of Coder user %q: %w", user, err)
+		}
+		user.LoginType = loginType
+	}
+	if p.skipServiceAccounts && user.LoginType == coderclient.LoginTypeNone {
+		return "service account", nil
+	}
+	if len(p.loginTypes) > 0 && !slices.Contains(p.loginTypes, user.LoginType) {
+		return fmt.Sprintf("login type %s", user.LoginType), nil
+	}
+	return "", nil
+}
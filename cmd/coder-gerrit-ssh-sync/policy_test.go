@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+func TestParseUserPolicy(t *testing.T) {
+	testCases := []struct {
+		name                string
+		loginTypes          []string
+		skipRoles           []string
+		skipServiceAccounts bool
+		expectErr           bool
+	}{
+		{name: "None"},
+		{name: "Policy", loginTypes: []string{"oidc", "github"}, skipRoles: []string{"owner"}, skipServiceAccounts: true},
+		{name: "Unknown_login_type", loginTypes: []string{"saml"}, expectErr: true},
+		{name: "Empty_role", skipRoles: []string{""}, expectErr: true},
+		{name: "Contradiction", loginTypes: []string{"none"}, skipServiceAccounts: true, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseUserPolicy(tc.loginTypes, tc.skipRoles, tc.skipServiceAccounts)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Errorf("parseUserPolicy() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestUserPolicyReject(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/users/id-unknown/login-type" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `{"login_type": "none"}`)
+	}))
+	defer server.Close()
+	c := coderclient.NewCoderClient(server.URL, "test-token")
+
+	oidcOnly := userPolicy{loginTypes: []coderclient.LoginType{coderclient.LoginTypeOIDC}}
+	noBots := userPolicy{skipServiceAccounts: true, skipRoles: []string{coderclient.RoleOwner, coderclient.RoleTemplateAdmin}}
+
+	testCases := []struct {
+		name     string
+		policy   userPolicy
+		user     coderclient.CoderUser
+		expected string
+	}{
+		{name: "No_policy", user: coderclient.CoderUser{ID: "id-1", LoginType: coderclient.LoginTypeNone}},
+		{name: "OIDC_user", policy: oidcOnly, user: coderclient.CoderUser{ID: "id-1", LoginType: coderclient.LoginTypeOIDC}},
+		{name: "Password_user", policy: oidcOnly, user: coderclient.CoderUser{ID: "id-1", LoginType: coderclient.LoginTypePassword}, expected: "login type password"},
+		{name: "Service_account", policy: noBots, user: coderclient.CoderUser{ID: "id-1", LoginType: coderclient.LoginTypeNone}, expected: "service account"},
+		{name: "Login_type_fetched", policy: noBots, user: coderclient.CoderUser{ID: "id-unknown"}, expected: "service account"},
+		{
+			name:     "Skipped_role",
+			policy:   noBots,
+			user:     coderclient.CoderUser{ID: "id-1", LoginType: coderclient.LoginTypeOIDC, Roles: []coderclient.CoderRole{{Name: "member"}, {Name: coderclient.RoleTemplateAdmin}}},
+			expected: "Coder role template-admin",
+		},
+		{name: "Human", policy: noBots, user: coderclient.CoderUser{ID: "id-1", LoginType: coderclient.LoginTypeOIDC, Roles: []coderclient.CoderRole{{Name: "member"}}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.policy.reject(ctx, c, &tc.user)
+			if err != nil {
+				t.Fatalf("reject() error = %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("reject() = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
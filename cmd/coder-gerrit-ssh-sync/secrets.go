@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// secretSource is where a secret is read from: a value given directly, a file
+// or a git-credential style helper command. Files and helpers are read again
+// on every read, so that rotated secrets are picked up without a restart.
+type secretSource struct {
+	// value is the secret given directly, such as from an environment
+	// variable.
+	value string
+
+	// file names a file holding the secret, such as a mounted Kubernetes
+	// secret. Surrounding whitespace is ignored.
+	file string
+
+	// helper is a command run by the shell with the argument "get", reading
+	// the protocol, host and path of url on stdin and writing the username and
+	// password on stdout, as git credential helpers do.
+	helper string
+
+	// url is the URL the secret is requested for from helper.
+	url string
+}
+
+// newSecretSource returns the source of the secret given as value, in file or
+// by helper, at most one of which may be set.
+func newSecretSource(value, file, helper, url string) (secretSource, error) {
+	set := 0
+	for _, s := range []string{value, file, helper} {
+		if s != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return secretSource{}, fmt.Errorf("only one of the value, the file and the credential helper may be set")
+	}
+	return secretSource{value: value, file: file, helper: helper, url: url}, nil
+}
+
+// empty reports whether no secret is set.
+func (s secretSource) empty() bool {
+	return s.value == "" && s.file == "" && s.helper == ""
+}
+
+// read returns the secret, and the username returned along with it by the
+// helper, if any.
+func (s secretSource) read(ctx context.Context) (username, secret string, err error) {
+	switch {
+	case s.file != "":
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return "", "", err
+		}
+		return "", strings.TrimSpace(string(data)), nil
+	case s.helper != "":
+		return s.runHelper(ctx)
+	default:
+		return "", s.value, nil
+	}
+}
+
+// runHelper requests the credentials of s.url from s.helper.
+func (s secretSource) runHelper(ctx context.Context) (username, password string, err error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return "", "", err
+	}
+	var stdin bytes.Buffer
+	fmt.Fprintf(&stdin, "protocol=%s\nhost=%s\n", u.Scheme, u.Host)
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		fmt.Fprintf(&stdin, "path=%s\n", path)
+	}
+	stdin.WriteString("\n")
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.helper+" get")
+	cmd.Stdin = &stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("run credential helper %q: %w", s.helper, err)
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		key, value, _ := strings.Cut(sc.Text(), "=")
+		switch key {
+		case "username":
+			username = value
+		case "password":
+			password = value
+		}
+	}
+	if password == "" {
+		return "", "", fmt.Errorf("credential helper %q returned no password", s.helper)
+	}
+	return username, password, nil
+}
+
+// credentials holds the credentials of the Coder and Gerrit clients, and
+// reloads them from their sources.
+type credentials struct {
+	coderToken     secretSource
+	gerritUsername string
+	gerritPassword secretSource
+
+	// last holds the credentials last loaded, to log changes.
+	last struct {
+		coderToken, gerritUsername, gerritPassword string
+	}
+}
+
+// load reads the credentials from their sources.
+func (c *credentials) load(ctx context.Context) (coderToken, gerritUsername, gerritPassword string, err error) {
+	if _, coderToken, err = c.coderToken.read(ctx); err != nil {
+		return "", "", "", fmt.Errorf("read Coder token: %w", err)
+	}
+	if coderToken == "" {
+		return "", "", "", fmt.Errorf("empty Coder token")
+	}
+	gerritUsername = c.gerritUsername
+	if !c.gerritPassword.empty() {
+		var username string
+		if username, gerritPassword, err = c.gerritPassword.read(ctx); err != nil {
+			return "", "", "", fmt.Errorf("read Gerrit password: %w", err)
+		}
+		if username != "" {
+			gerritUsername = username
+		}
+	}
+	c.last.coderToken, c.last.gerritUsername, c.last.gerritPassword = coderToken, gerritUsername, gerritPassword
+	return coderToken, gerritUsername, gerritPassword, nil
+}
+
+// reload reads the credentials from their sources again, and sets those that
+// changed in the Coder client and with setGerrit. Clients keep their
+// credentials if they cannot be read.
+func (c *credentials) reload(ctx context.Context, coder interface{ SetToken(string) }, setGerrit func(username, secret string) error) error {
+	last := c.last
+	coderToken, gerritUsername, gerritPassword, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+	if coderToken != last.coderToken {
+		log.Printf("Coder token changed, reloading it")
+		coder.SetToken(coderToken)
+	}
+	if gerritUsername != last.gerritUsername || gerritPassword != last.gerritPassword {
+		log.Printf("Gerrit credentials changed, reloading them")
+		if err := setGerrit(gerritUsername, gerritPassword); err != nil {
+			return fmt.Errorf("set Gerrit credentials: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewSecretSource(t *testing.T) {
+	if _, err := newSecretSource("token", "", "", ""); err != nil {
+		t.Errorf("newSecretSource() of a value error = %v", err)
+	}
+	if _, err := newSecretSource("token", "/run/secrets/token", "", ""); err == nil {
+		t.Errorf("newSecretSource() of a value and a file succeeded, want error")
+	}
+	if _, err := newSecretSource("", "/run/secrets/token", "pass coder", ""); err == nil {
+		t.Errorf("newSecretSource() of a file and a helper succeeded, want error")
+	}
+	if s, _ := newSecretSource("", "", "", ""); !s.empty() {
+		t.Errorf("empty() = false without secret, want true")
+	}
+}
+
+func TestSecretSourceRead(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token")
+	if err := os.WriteFile(file, []byte("first-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The helper echoes the host and path it was asked for as the password.
+	helper := filepath.Join(dir, "helper")
+	script := "#!/bin/sh\n" +
+		"test \"$1\" = get || exit 1\n" +
+		"while read -r line && [ -n \"$line\" ]; do\n" +
+		"  case $line in host=*) host=${line#host=};; path=*) path=${line#path=};; esac\n" +
+		"done\n" +
+		"echo username=bot\n" +
+		"echo \"password=$host/$path\"\n"
+	if err := os.WriteFile(helper, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name             string
+		source           secretSource
+		expectedUsername string
+		expectedSecret   string
+		expectErr        bool
+	}{
+		{name: "Value", source: secretSource{value: "token"}, expectedSecret: "token"},
+		{name: "File", source: secretSource{file: file}, expectedSecret: "first-token"},
+		{name: "Missing_file", source: secretSource{file: filepath.Join(dir, "missing")}, expectErr: true},
+		{
+			name:             "Helper",
+			source:           secretSource{helper: helper, url: "https://gerrit.example.com/r/"},
+			expectedUsername: "bot",
+			expectedSecret:   "gerrit.example.com/r",
+		},
+		{name: "Failing_helper", source: secretSource{helper: "false", url: "https://gerrit.example.com"}, expectErr: true},
+		{name: "Helper_without_password", source: secretSource{helper: "echo username=bot; true", url: "https://gerrit.example.com"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			username, secret, err := tc.source.read(ctx)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("read() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+			if username != tc.expectedUsername || secret != tc.expectedSecret {
+				t.Errorf("read() = %q, %q, want %q, %q", username, secret, tc.expectedUsername, tc.expectedSecret)
+			}
+		})
+	}
+}
+
+// credentialsRecorder records the credentials set in clients.
+type credentialsRecorder struct {
+	tokens []string
+	auths  []string
+}
+
+func (r *credentialsRecorder) SetToken(token string) {
+	r.tokens = append(r.tokens, token)
+}
+
+func (r *credentialsRecorder) setGerrit(username, password string) error {
+	r.auths = append(r.auths, username+":"+password)
+	return nil
+}
+
+func TestCredentialsReload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	passwordFile := filepath.Join(dir, "password")
+	write := func(file, content string) {
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(tokenFile, "token-1")
+	write(passwordFile, "password-1")
+
+	c := &credentials{
+		coderToken:     secretSource{file: tokenFile},
+		gerritUsername: "bot",
+		gerritPassword: secretSource{file: passwordFile},
+	}
+	if _, _, _, err := c.load(ctx); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	r := &credentialsRecorder{}
+	if err := c.reload(ctx, r, r.setGerrit); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	write(tokenFile, "token-2\n")
+	if err := c.reload(ctx, r, r.setGerrit); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	write(passwordFile, "password-2")
+	if err := c.reload(ctx, r, r.setGerrit); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	write(tokenFile, "")
+	if err := c.reload(ctx, r, r.setGerrit); err == nil {
+		t.Errorf("reload() of an empty token succeeded, want error")
+	}
+
+	if diff := cmp.Diff([]string{"token-2"}, r.tokens); diff != "" {
+		t.Errorf("Coder tokens mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"bot:password-2"}, r.auths); diff != "" {
+		t.Errorf("Gerrit credentials mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+// selectorKind names what a userSelector compares with Coder users.
+type selectorKind string
+
+const (
+	// selectorEmail selects the user with the email, ignoring case.
+	selectorEmail selectorKind = "email"
+
+	// selectorUsername selects the user with the username.
+	selectorUsername selectorKind = "username"
+
+	// selectorID selects the user with the ID.
+	selectorID selectorKind = "id"
+
+	// selectorGlob selects users whose username or email matches the glob
+	// pattern, as understood by path.Match.
+	selectorGlob selectorKind = "glob"
+
+	// selectorRegex selects users whose username or email matches the
+	// regular expression, which is not anchored.
+	selectorRegex selectorKind = "regex"
+)
+
+// userSelector selects Coder users by a single criterion.
+type userSelector struct {
+	kind  selectorKind
+	value string
+
+	// re is the compiled value of selectorRegex selectors.
+	re *regexp.Regexp
+}
+
+// userSelection selects the Coder users matching any of its selectors. An
+// empty selection selects every user.
+type userSelection []userSelector
+
+// parseUserSelection validates the values of the --select flag, each of the
+// form "kind:value", and adds an email selector for every value of --only.
+func parseUserSelection(selectors, onlyEmails []string) (userSelection, error) {
+	var sel userSelection
+	for _, email := range onlyEmails {
+		if email == "" {
+			return nil, fmt.Errorf("empty email")
+		}
+		sel = append(sel, userSelector{kind: selectorEmail, value: email})
+	}
+	for _, s := range selectors {
+		kind, value, ok := strings.Cut(s, ":")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid selector %q, want kind:value", s)
+		}
+		u := userSelector{kind: selectorKind(kind), value: value}
+		switch u.kind {
+		case selectorEmail, selectorUsername, selectorID:
+		case selectorGlob:
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("selector %q: %w", s, err)
+			}
+		case selectorRegex:
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("selector %q: %w", s, err)
+			}
+			u.re = re
+		default:
+			return nil, fmt.Errorf("unknown selector kind %q", kind)
+		}
+		sel = append(sel, u)
+	}
+	return sel, nil
+}
+
+// selects reports whether user is selected by sel.
+func (sel userSelection) selects(user *coderclient.CoderUser) bool {
+	if len(sel) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(sel, func(u userSelector) bool {
+		return u.selects(user)
+	})
+}
+
+// selects reports whether user is selected by u.
+func (u userSelector) selects(user *coderclient.CoderUser) bool {
+	switch u.kind {
+	case selectorEmail:
+		return user.Email != "" && strings.EqualFold(user.Email, u.value)
+	case selectorUsername:
+		return user.Username == u.value
+	case selectorID:
+		return user.ID == u.value
+	case selectorGlob:
+		for _, s := range []string{user.Username, user.Email} {
+			if ok, _ := path.Match(u.value, s); ok && s != "" {
+				return true
+			}
+		}
+	case selectorRegex:
+		return u.re.MatchString(user.Username) || (user.Email != "" && u.re.MatchString(user.Email))
+	}
+	return false
+}
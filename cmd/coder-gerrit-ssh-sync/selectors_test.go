@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/coderclient"
+)
+
+func TestParseUserSelection(t *testing.T) {
+	testCases := []struct {
+		name      string
+		selectors []string
+		only      []string
+		expectErr bool
+	}{
+		{name: "None"},
+		{name: "Only", only: []string{"alice@example.com", "bob@example.com"}},
+		{name: "Selectors", selectors: []string{"email:alice@example.com", "username:bob", "id:id-1", "glob:team-*", "regex:^ops-(a|b)$"}},
+		{name: "Empty_only", only: []string{""}, expectErr: true},
+		{name: "No_kind", selectors: []string{"alice"}, expectErr: true},
+		{name: "No_value", selectors: []string{"username:"}, expectErr: true},
+		{name: "Unknown_kind", selectors: []string{"group:team"}, expectErr: true},
+		{name: "Invalid_glob", selectors: []string{"glob:team-["}, expectErr: true},
+		{name: "Invalid_regex", selectors: []string{"regex:team-("}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sel, err := parseUserSelection(tc.selectors, tc.only)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("parseUserSelection() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+			if err == nil && len(sel) != len(tc.selectors)+len(tc.only) {
+				t.Errorf("parseUserSelection() returned %d selectors, want %d", len(sel), len(tc.selectors)+len(tc.only))
+			}
+		})
+	}
+}
+
+func TestUserSelectionSelects(t *testing.T) {
+	users := []coderclient.CoderUser{
+		{ID: "id-1", Username: "alice", Email: "Alice@example.com"},
+		{ID: "id-2", Username: "team-bob", Email: "bob@example.com"},
+		{ID: "id-3", Username: "carol", Email: "carol@ops.example.com"},
+		{ID: "id-4", Username: "dave"},
+	}
+
+	testCases := []struct {
+		name      string
+		selectors []string
+		only      []string
+		expected  []string
+	}{
+		{name: "All", expected: []string{"id-1", "id-2", "id-3", "id-4"}},
+		{name: "Only", only: []string{"alice@example.com"}, expected: []string{"id-1"}},
+		{name: "Username", selectors: []string{"username:dave"}, expected: []string{"id-4"}},
+		{name: "ID", selectors: []string{"id:id-2", "id:id-3"}, expected: []string{"id-2", "id-3"}},
+		{name: "Glob_username", selectors: []string{"glob:team-*"}, expected: []string{"id-2"}},
+		{name: "Glob_email", selectors: []string{"glob:*@ops.example.com"}, expected: []string{"id-3"}},
+		{name: "Regex", selectors: []string{"regex:^(alice|dave)$"}, expected: []string{"id-1", "id-4"}},
+		{name: "Any", selectors: []string{"username:carol"}, only: []string{"bob@example.com"}, expected: []string{"id-2", "id-3"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sel, err := parseUserSelection(tc.selectors, tc.only)
+			if err != nil {
+				t.Fatalf("parseUserSelection() error = %v", err)
+			}
+			var got []string
+			for _, u := range users {
+				if sel.selects(&u) {
+					got = append(got, u.ID)
+				}
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("selected users mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReconcileCoderQuery(t *testing.T) {
+	ctx := context.Background()
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("q"))
+		fmt.Fprintln(w, `{"users": [], "count": 0}`)
+	}))
+	defer server.Close()
+
+	s := &syncer{coder: coderclient.NewCoderClient(server.URL, "test-token"), gerrit: &concurrentGerrit{}}
+	if err := reconcile(ctx, ctx, &config{concurrency: 1, coderQuery: "status:active"}, s); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"status:active"}, queries); diff != "" {
+		t.Errorf("Coder queries mismatch (-want +got):\n%s", diff)
+	}
+}
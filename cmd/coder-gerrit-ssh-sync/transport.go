@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// httpOptions configures the connections to a backend, Coder or Gerrit.
+type httpOptions struct {
+	// caFile names a PEM bundle of certificate authorities trusted in
+	// addition to the system ones.
+	caFile string
+
+	// certFile and keyFile name the PEM client certificate and key sent for
+	// mutual TLS.
+	certFile string
+	keyFile  string
+
+	// proxy is the URL of the HTTP proxy of requests, or "" to use the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+	proxy string
+
+	// insecureSkipVerify disables the verification of server certificates.
+	insecureSkipVerify bool
+
+	// timeout limits each attempt of a request, so that retries get their
+	// own; unlimited if zero.
+	timeout time.Duration
+}
+
+// registerHTTPFlags registers the flags of the httpOptions of backend, named
+// name in usages, on fs.
+func registerHTTPFlags(fs *flag.FlagSet, backend, name string) *httpOptions {
+	opts := &httpOptions{}
+	fs.StringVar(&opts.caFile, backend+"-ca-file", "", "PEM bundle of certificate authorities trusted for "+name+" in addition to the system ones")
+	fs.StringVar(&opts.certFile, backend+"-client-cert", "", "PEM client certificate sent to "+name+" for mutual TLS, with --"+backend+"-client-key")
+	fs.StringVar(&opts.keyFile, backend+"-client-key", "", "PEM key of --"+backend+"-client-cert")
+	fs.StringVar(&opts.proxy, backend+"-proxy", "", "URL of the HTTP proxy of requests to "+name+"; HTTPS_PROXY, HTTP_PROXY and NO_PROXY are used if empty")
+	fs.BoolVar(&opts.insecureSkipVerify, backend+"-insecure-skip-verify", false, "Do not verify the certificate of "+name+"; for test instances only")
+	fs.DurationVar(&opts.timeout, backend+"-timeout", 0, "Time limit of each attempt of a request to "+name+", every retry getting its own; unlimited if zero")
+	return opts
+}
+
+// check reports options that are inconsistent, without reading files.
+func (o *httpOptions) check() error {
+	if (o.certFile == "") != (o.keyFile == "") {
+		return fmt.Errorf("the client certificate and key must be given together")
+	}
+	if o.proxy != "" {
+		u, err := url.Parse(o.proxy)
+		if err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("proxy %q has no host", o.proxy)
+		}
+	}
+	if o.timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// transport returns a transport like http.DefaultTransport configured with o.
+func (o *httpOptions) transport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if o == nil {
+		return t, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecureSkipVerify}
+	if o.caFile != "" {
+		pem, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", o.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	t.TLSClientConfig = tlsConfig
+
+	if o.proxy != "" {
+		u, err := url.Parse(o.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return t, nil
+}
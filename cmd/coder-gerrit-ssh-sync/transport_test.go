@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jingyuanliang/coder-gerrit-ssh-sync/pkg/retry"
+)
+
+func TestHTTPOptionsCheck(t *testing.T) {
+	testCases := []struct {
+		name      string
+		opts      httpOptions
+		expectErr bool
+	}{
+		{name: "Default"},
+		{name: "Client_certificate", opts: httpOptions{certFile: "client.pem", keyFile: "client.key"}},
+		{name: "Client_certificate_without_key", opts: httpOptions{certFile: "client.pem"}, expectErr: true},
+		{name: "Client_key_without_certificate", opts: httpOptions{keyFile: "client.key"}, expectErr: true},
+		{name: "Proxy", opts: httpOptions{proxy: "http://proxy.example.com:3128"}},
+		{name: "Proxy_without_host", opts: httpOptions{proxy: "proxy.example.com"}, expectErr: true},
+		{name: "Negative_timeout", opts: httpOptions{timeout: -time.Second}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.opts.check()
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Errorf("check() error = %v, want error presence = %v", err, tc.expectErr)
+			}
+		})
+	}
+}
+
+// writeServerPEM writes the certificate and the key of server to PEM files in
+// dir, and returns their names.
+func writeServerPEM(t *testing.T, server *httptest.Server, dir string) (certFile, keyFile string) {
+	t.Helper()
+	cert := server.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewHTTPClientTLS(t *testing.T) {
+	var clientCerts int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCerts = len(r.TLS.PeerCertificates)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile := writeServerPEM(t, server, dir)
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name                string
+		opts                *httpOptions
+		expectClientErr     bool
+		expectRequestErr    bool
+		expectedClientCerts int
+	}{
+		{name: "Untrusted", opts: &httpOptions{}, expectRequestErr: true},
+		{name: "CA_file", opts: &httpOptions{caFile: certFile}},
+		{name: "Insecure_skip_verify", opts: &httpOptions{insecureSkipVerify: true}},
+		{name: "Client_certificate", opts: &httpOptions{caFile: certFile, certFile: certFile, keyFile: keyFile}, expectedClientCerts: 1},
+		{name: "Missing_CA_file", opts: &httpOptions{caFile: filepath.Join(dir, "missing.pem")}, expectClientErr: true},
+		{name: "Invalid_CA_file", opts: &httpOptions{caFile: garbage}, expectClientErr: true},
+		{name: "Mismatched_client_key", opts: &httpOptions{certFile: certFile, keyFile: certFile}, expectClientErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientCerts = 0
+			client, err := newHTTPClient(retry.Policy{}, 0, tc.opts)
+			if gotErr := err != nil; gotErr != tc.expectClientErr {
+				t.Fatalf("newHTTPClient() error = %v, want error presence = %v", err, tc.expectClientErr)
+			}
+			if err != nil {
+				return
+			}
+			resp, err := client.Get(server.URL)
+			if gotErr := err != nil; gotErr != tc.expectRequestErr {
+				t.Fatalf("Get() error = %v, want error presence = %v", err, tc.expectRequestErr)
+			}
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			if clientCerts != tc.expectedClientCerts {
+				t.Errorf("server got %d client certificates, want %d", clientCerts, tc.expectedClientCerts)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(retry.Policy{}, 0, &httpOptions{proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("newHTTPClient() error = %v", err)
+	}
+	resp, err := client.Get("http://coder.example.com/api/v2/buildinfo")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if requested != "http://coder.example.com/api/v2/buildinfo" {
+		t.Errorf("proxy got request for %q, want http://coder.example.com/api/v2/buildinfo", requested)
+	}
+}
+
+func TestNewHTTPClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := newHTTPClient(retry.Policy{}, 0, &httpOptions{timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newHTTPClient() error = %v", err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("Get() of a hanging server succeeded, want timeout")
+	}
+}
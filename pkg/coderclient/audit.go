@@ -0,0 +1,76 @@
+package coderclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// CoderAuditLog is a single entry of the Coder audit log.
+type CoderAuditLog struct {
+	ID             string          `json:"id"`
+	RequestID      string          `json:"request_id"`
+	Time           time.Time       `json:"time"`
+	IP             string          `json:"ip,omitempty"`
+	UserAgent      string          `json:"user_agent,omitempty"`
+	ResourceType   string          `json:"resource_type"`
+	ResourceID     string          `json:"resource_id"`
+	ResourceTarget string          `json:"resource_target"`
+	Action         string          `json:"action"`
+	Diff           json.RawMessage `json:"diff,omitempty"`
+	StatusCode     int             `json:"status_code"`
+	Description    string          `json:"description"`
+	OrganizationID string          `json:"organization_id,omitempty"`
+	User           *CoderUser      `json:"user,omitempty"`
+}
+
+// CoderAuditLogsResponse represents a page of audit log entries.
+type CoderAuditLogsResponse struct {
+	AuditLogs []CoderAuditLog `json:"audit_logs"`
+
+	// Count is the total number of entries matching the query, across all
+	// pages.
+	Count int `json:"count"`
+}
+
+// AuditLogsOptions specifies parameters for listing Coder audit logs.
+type AuditLogsOptions struct {
+	// Query is a Coder audit log search query, such as
+	// "resource_type:git_ssh_key action:write".
+	Query string
+
+	// Limit is the maximum number of entries in a page.
+	Limit int
+
+	// Offset skips this many entries.
+	Offset int
+}
+
+// values returns opts as query parameters of the audit endpoint.
+func (opts *AuditLogsOptions) values() url.Values {
+	v := url.Values{}
+	if opts == nil {
+		return v
+	}
+	if opts.Query != "" {
+		v.Set("q", opts.Query)
+	}
+	if opts.Limit > 0 {
+		v.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		v.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	return v
+}
+
+// AuditLogs returns a page of audit log entries matching opts, newest first.
+func (c *CoderClient) AuditLogs(ctx context.Context, opts *AuditLogsOptions) (*CoderAuditLogsResponse, error) {
+	var logs CoderAuditLogsResponse
+	if err := c.get(ctx, "/api/v2/audit", opts.values(), &logs); err != nil {
+		return nil, err
+	}
+	return &logs, nil
+}
@@ -0,0 +1,39 @@
+package coderclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/audit" {
+			t.Errorf("got path %q, want %q", r.URL.Path, "/api/v2/audit")
+		}
+		q := r.URL.Query()
+		if q.Get("q") != "resource_type:git_ssh_key" || q.Get("limit") != "10" || q.Get("offset") != "20" {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+		}
+		fmt.Fprintln(w, `{"audit_logs": [{"id": "a1", "action": "write", "resource_type": "git_ssh_key", "user": {"id": "id-1"}}], "count": 21}`)
+	}))
+	defer server.Close()
+
+	client := NewCoderClient(server.URL, "test-token")
+	got, err := client.AuditLogs(context.Background(), &AuditLogsOptions{
+		Query:  "resource_type:git_ssh_key",
+		Limit:  10,
+		Offset: 20,
+	})
+	if err != nil {
+		t.Fatalf("AuditLogs() error = %v", err)
+	}
+	if got.Count != 21 || len(got.AuditLogs) != 1 {
+		t.Fatalf("AuditLogs() = %+v, want 1 entry and count 21", got)
+	}
+	if entry := got.AuditLogs[0]; entry.Action != "write" || entry.User == nil || entry.User.ID != "id-1" {
+		t.Errorf("AuditLogs() entry = %+v", entry)
+	}
+}
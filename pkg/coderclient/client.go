@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"iter"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type UserStatus string
@@ -16,11 +20,25 @@ const (
 	UserStatusSuspended UserStatus = "suspended"
 )
 
+// LoginType is the way a Coder user logs in.
+type LoginType string
+
+const (
+	LoginTypePassword LoginType = "password"
+	LoginTypeGithub   LoginType = "github"
+	LoginTypeOIDC     LoginType = "oidc"
+	LoginTypeToken    LoginType = "token"
+	LoginTypeNone     LoginType = "none"
+)
+
 // CoderClient is a client for interacting with the Coder API.
 type CoderClient struct {
 	// url is the base URL of Coder API.
 	url string
 
+	// mu guards token.
+	mu sync.RWMutex
+
 	// token is the authentication token for API requests.
 	token string
 
@@ -30,17 +48,73 @@ type CoderClient struct {
 
 // CoderBuildInfoResponse includes the version of the Coder system.
 type CoderBuildInfoResponse struct {
-	Version string `json:"version"`
+	Version      string `json:"version"`
+	ExternalURL  string `json:"external_url,omitempty"`
+	DashboardURL string `json:"dashboard_url,omitempty"`
+	DeploymentID string `json:"deployment_id,omitempty"`
 }
 
 // CoderUsersResponse represents list of users retrieved from API.
 type CoderUsersResponse struct {
 	Users []CoderUser `json:"users"`
+
+	// Count is the total number of users matching the query across all pages,
+	// counting only the users after the after_id parameter, if set. A page
+	// requested after the last user thus reports zero.
+	Count int `json:"count"`
+}
+
+// DefaultUsersPageSize is the number of users fetched per request by ListUsers
+// when ListUsersOptions.Limit is not set.
+const DefaultUsersPageSize = 100
+
+// ListUsersOptions specifies parameters for listing Coder users.
+type ListUsersOptions struct {
+	// Query is a Coder user search query, such as "status:active".
+	Query string
+
+	// Limit is the maximum number of users in a page.
+	Limit int
+
+	// Offset skips this many users. It is ignored by ListUsers.
+	Offset int
+
+	// AfterID starts the page after the user with this ID.
+	AfterID string
+
+	// Count, if not nil, is set by ListUsers to the total number of users
+	// matching Query after AfterID, as reported by Coder with the first page.
+	// Later pages are not used, since Coder counts only the users after them.
+	Count *int
+}
+
+// values returns opts as query parameters of the users endpoint.
+func (opts *ListUsersOptions) values() url.Values {
+	v := url.Values{}
+	if opts == nil {
+		return v
+	}
+	if opts.Query != "" {
+		v.Set("q", opts.Query)
+	}
+	if opts.Limit > 0 {
+		v.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		v.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.AfterID != "" {
+		v.Set("after_id", opts.AfterID)
+	}
+	return v
 }
 
 // CoderUserGitSSHKeyResponse includes user SSH key.
 type CoderUserGitSSHKeyResponse struct {
-	PublicKey string `json:"public_key"`
+	UserID    string    `json:"user_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	PublicKey string    `json:"public_key"`
 }
 
 // CoderUser represents the user details retrieved from Coder API.
@@ -49,15 +123,80 @@ type CoderUser struct {
 	ID       string     `json:"id"`
 	Username string     `json:"username"`
 	Status   UserStatus `json:"status"`
+
+	// LoginType is the way the user logs in, linking it to an external
+	// identity with LoginTypeGithub and LoginTypeOIDC.
+	LoginType LoginType `json:"login_type,omitempty"`
+
+	// Roles lists the site-wide roles of the user.
+	Roles []CoderRole `json:"roles,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// LastSeenAt is when the user last used Coder. It is zero for users that
+	// never did.
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// Role names of site-wide Coder roles.
+const (
+	RoleOwner         = "owner"
+	RoleTemplateAdmin = "template-admin"
+	RoleUserAdmin     = "user-admin"
+	RoleAuditor       = "auditor"
+)
+
+// CoderRole is a role granted to a Coder user.
+type CoderRole struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+
+	// OrganizationID is the organization of organization roles, and empty
+	// for site-wide roles.
+	OrganizationID string `json:"organization_id,omitempty"`
+}
+
+// HasRole reports whether u has the site-wide role name.
+func (u *CoderUser) HasRole(name string) bool {
+	for _, r := range u.Roles {
+		if r.Name == name && r.OrganizationID == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Option configures a CoderClient.
+type Option func(*CoderClient)
+
+// WithHTTPClient makes the CoderClient send its requests with client instead
+// of http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *CoderClient) {
+		c.client = client
+	}
 }
 
 // NewCoderClient returns a pointer coderClient (reference).
-func NewCoderClient(url string, token string) *CoderClient {
-	return &CoderClient{
+func NewCoderClient(url string, token string, opts ...Option) *CoderClient {
+	c := &CoderClient{
 		url:    url,
 		token:  token,
 		client: http.DefaultClient, // Assign http global client reference to client
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetToken replaces the authentication token of the following requests, such
+// as after the token was rotated.
+func (c *CoderClient) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
 }
 
 func (u *CoderUser) String() string {
@@ -67,11 +206,113 @@ func (u *CoderUser) String() string {
 // Get sends an HTTP GET request to the specified path using the coderClient.
 // It decodes the JSON response into the target variable.
 func (c *CoderClient) Get(ctx context.Context, path string, target any) error {
+	return c.get(ctx, path, nil, target)
+}
+
+// BuildInfo returns the build information of the Coder deployment.
+func (c *CoderClient) BuildInfo(ctx context.Context) (*CoderBuildInfoResponse, error) {
+	var bi CoderBuildInfoResponse
+	if err := c.get(ctx, "/api/v2/buildinfo", nil, &bi); err != nil {
+		return nil, err
+	}
+	return &bi, nil
+}
+
+// GetUser returns the user identified by user, which is either a user ID, a
+// username or "me" for the authenticated user.
+func (c *CoderClient) GetUser(ctx context.Context, user string) (*CoderUser, error) {
+	var u CoderUser
+	if err := c.get(ctx, "/api/v2/users/"+url.PathEscape(user), nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetGitSSHKey returns the Git SSH key of the user identified by user, which
+// is either a user ID, a username or "me" for the authenticated user.
+func (c *CoderClient) GetGitSSHKey(ctx context.Context, user string) (*CoderUserGitSSHKeyResponse, error) {
+	var key CoderUserGitSSHKeyResponse
+	if err := c.get(ctx, "/api/v2/users/"+url.PathEscape(user)+"/gitsshkey", nil, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetUserLoginType returns the login type of the user identified by user,
+// which is either a user ID, a username or "me" for the authenticated user.
+func (c *CoderClient) GetUserLoginType(ctx context.Context, user string) (LoginType, error) {
+	var resp struct {
+		LoginType LoginType `json:"login_type"`
+	}
+	if err := c.get(ctx, "/api/v2/users/"+url.PathEscape(user)+"/login-type", nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.LoginType, nil
+}
+
+// ListUsersPage fetches a single page of users as specified by opts.
+func (c *CoderClient) ListUsersPage(ctx context.Context, opts *ListUsersOptions) (*CoderUsersResponse, error) {
+	var page CoderUsersResponse
+	if err := c.get(ctx, "/api/v2/users", opts.values(), &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// ListUsers returns an iterator over every user matching opts, fetching pages
+// of opts.Limit users as needed. Pages are chained with the after_id
+// parameter, so users are neither skipped nor repeated when users are added or
+// removed while iterating. Since Coder may return fewer users than requested,
+// only an empty page ends the iteration. Iteration stops after the first error.
+func (c *CoderClient) ListUsers(ctx context.Context, opts *ListUsersOptions) iter.Seq2[CoderUser, error] {
+	return func(yield func(CoderUser, error) bool) {
+		pageOpts := ListUsersOptions{Limit: DefaultUsersPageSize}
+		if opts != nil {
+			pageOpts.Query = opts.Query
+			pageOpts.AfterID = opts.AfterID
+			if opts.Limit > 0 {
+				pageOpts.Limit = opts.Limit
+			}
+		}
+
+		for first := true; ; first = false {
+			page, err := c.ListUsersPage(ctx, &pageOpts)
+			if err != nil {
+				if pageOpts.AfterID == "" {
+					err = fmt.Errorf("list users: %w", err)
+				} else {
+					err = fmt.Errorf("list users after %q: %w", pageOpts.AfterID, err)
+				}
+				yield(CoderUser{}, err)
+				return
+			}
+			if first && opts != nil && opts.Count != nil {
+				*opts.Count = page.Count
+			}
+			if len(page.Users) == 0 {
+				return
+			}
+			for _, u := range page.Users {
+				if !yield(u, nil) {
+					return
+				}
+			}
+			pageOpts.AfterID = page.Users[len(page.Users)-1].ID
+		}
+	}
+}
+
+// get sends an HTTP GET request to path with the query parameters query, and
+// decodes the JSON response into target.
+func (c *CoderClient) get(ctx context.Context, path string, query url.Values, target any) error {
 
 	fullURL, err := url.JoinPath(c.url, path)
 	if err != nil {
 		return fmt.Errorf("failed to join URL path: %w", err)
 	}
+	if len(query) > 0 {
+		fullURL += "?" + query.Encode()
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
@@ -79,7 +320,9 @@ func (c *CoderClient) Get(ctx context.Context, path string, target any) error {
 	}
 
 	req.Header.Set("Accept", "application/json")
+	c.mu.RLock()
 	req.Header.Set("Coder-Session-Token", c.token)
+	c.mu.RUnlock()
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return err
@@ -87,7 +330,7 @@ func (c *CoderClient) Get(ctx context.Context, path string, target any) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Coder HTTP status: %s", resp.Status)
+		return newError(resp)
 	}
 
 	return json.NewDecoder(resp.Body).Decode(target)
@@ -2,10 +2,13 @@ package coderclient
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -115,3 +118,285 @@ func TestGet(t *testing.T) {
 		})
 	}
 }
+
+func TestListUsers(t *testing.T) {
+	ctx := context.Background()
+
+	// allUsers are served in pages, as Coder does, ordered by username.
+	var allUsers []CoderUser
+	for i := range 7 {
+		allUsers = append(allUsers, CoderUser{ID: fmt.Sprintf("id-%d", i), Username: fmt.Sprintf("user%d", i)})
+	}
+
+	testCases := []struct {
+		name          string
+		opts          *ListUsersOptions
+		maxLimit      int
+		failAfterID   string
+		expectedIDs   []string
+		expectedPages int
+		expectErr     bool
+	}{
+		{
+			name:          "All_pages",
+			opts:          &ListUsersOptions{Limit: 3, Query: "status:active"},
+			expectedIDs:   []string{"id-0", "id-1", "id-2", "id-3", "id-4", "id-5", "id-6"},
+			expectedPages: 4,
+		},
+		{
+			name:          "Exact_multiple_of_limit",
+			opts:          &ListUsersOptions{Limit: 7, Query: "status:active"},
+			expectedIDs:   []string{"id-0", "id-1", "id-2", "id-3", "id-4", "id-5", "id-6"},
+			expectedPages: 2,
+		},
+		{
+			name:          "Default_limit",
+			opts:          nil,
+			expectedIDs:   []string{"id-0", "id-1", "id-2", "id-3", "id-4", "id-5", "id-6"},
+			expectedPages: 2,
+		},
+		{
+			// Short pages do not end the iteration.
+			name:          "Server_caps_limit",
+			opts:          &ListUsersOptions{Limit: 5},
+			maxLimit:      2,
+			expectedIDs:   []string{"id-0", "id-1", "id-2", "id-3", "id-4", "id-5", "id-6"},
+			expectedPages: 5,
+		},
+		{
+			name:          "Error_on_second_page",
+			opts:          &ListUsersOptions{Limit: 3},
+			failAfterID:   "id-2",
+			expectedIDs:   []string{"id-0", "id-1", "id-2"},
+			expectedPages: 2,
+			expectErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pages := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				pages++
+				q := r.URL.Query()
+				if r.URL.Path != "/api/v2/users" {
+					t.Errorf("unexpected path %q", r.URL.Path)
+				}
+				if tc.opts != nil && q.Get("q") != tc.opts.Query {
+					t.Errorf("got query %q, want %q", q.Get("q"), tc.opts.Query)
+				}
+				afterID := q.Get("after_id")
+				if afterID != "" && afterID == tc.failAfterID {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				limit := DefaultUsersPageSize
+				if l := q.Get("limit"); l != "" {
+					fmt.Sscan(l, &limit)
+				}
+				if tc.maxLimit > 0 {
+					limit = min(limit, tc.maxLimit)
+				}
+
+				start := 0
+				for i, u := range allUsers {
+					if u.ID == afterID {
+						start = i + 1
+					}
+				}
+				end := min(start+limit, len(allUsers))
+				json.NewEncoder(w).Encode(CoderUsersResponse{Users: allUsers[start:end], Count: len(allUsers)})
+			}))
+			defer server.Close()
+
+			client := NewCoderClient(server.URL, "test-token")
+
+			var gotIDs []string
+			var gotErr error
+			for u, err := range client.ListUsers(ctx, tc.opts) {
+				if err != nil {
+					gotErr = err
+					break
+				}
+				gotIDs = append(gotIDs, u.ID)
+			}
+
+			if (gotErr != nil) != tc.expectErr {
+				t.Errorf("got error = %v, want error presence = %v", gotErr, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedIDs, gotIDs); diff != "" {
+				t.Errorf("user IDs mismatch (-want +got):\n%s", diff)
+			}
+			if pages != tc.expectedPages {
+				t.Errorf("fetched %d pages, want %d", pages, tc.expectedPages)
+			}
+		})
+	}
+}
+
+func TestListUsersCount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("after_id") {
+		case "":
+			fmt.Fprintln(w, `{"users": [{"id": "id-0"}], "count": 2}`)
+		case "id-0":
+			fmt.Fprintln(w, `{"users": [{"id": "id-1"}], "count": 1}`)
+		default:
+			// Coder counts the users after after_id only.
+			fmt.Fprintln(w, `{"users": [], "count": 0}`)
+		}
+	}))
+	defer server.Close()
+
+	client := NewCoderClient(server.URL, "test-token")
+	count := -1
+	listed := 0
+	for _, err := range client.ListUsers(context.Background(), &ListUsersOptions{Count: &count}) {
+		if err != nil {
+			t.Fatalf("ListUsers() error = %v", err)
+		}
+		listed++
+	}
+	if listed != 2 || count != 2 {
+		t.Errorf("ListUsers() listed %d users with count %d, want 2 and 2", listed, count)
+	}
+}
+
+func TestListUsersFirstPageError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := NewCoderClient(server.URL, "test-token")
+	for _, err := range client.ListUsers(context.Background(), nil) {
+		if err == nil || strings.Contains(err.Error(), "after") {
+			t.Errorf("ListUsers() error = %v, want error of the first page", err)
+		}
+	}
+}
+
+func TestListUsersPageCount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("offset"); got != "20" {
+			t.Errorf("got offset %q, want %q", got, "20")
+		}
+		fmt.Fprintln(w, `{"users": [{"id": "id-20"}], "count": 3000}`)
+	}))
+	defer server.Close()
+
+	client := NewCoderClient(server.URL, "test-token")
+	page, err := client.ListUsersPage(context.Background(), &ListUsersOptions{Limit: 20, Offset: 20})
+	if err != nil {
+		t.Fatalf("ListUsersPage() error = %v", err)
+	}
+	if page.Count != 3000 || len(page.Users) != 1 {
+		t.Errorf("ListUsersPage() = %+v, want 1 user and count 3000", page)
+	}
+}
+
+func TestSetToken(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Coder-Session-Token"))
+		fmt.Fprintln(w, `{}`)
+	}))
+	defer server.Close()
+
+	c := NewCoderClient(server.URL, "old-token")
+	if _, err := c.BuildInfo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.SetToken("new-token")
+	if _, err := c.BuildInfo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"old-token", "new-token"}, tokens); diff != "" {
+		t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCoderUserHasRole(t *testing.T) {
+	u := &CoderUser{Roles: []CoderRole{{Name: RoleTemplateAdmin}, {Name: "organization-admin", OrganizationID: "org-1"}}}
+	if !u.HasRole(RoleTemplateAdmin) {
+		t.Errorf("HasRole(%q) = false, want true", RoleTemplateAdmin)
+	}
+	if u.HasRole(RoleOwner) || u.HasRole("organization-admin") {
+		t.Errorf("HasRole() = true for a missing or organization role, want false")
+	}
+}
+
+func TestTypedUserMethods(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name         string
+		call         func(c *CoderClient) (any, error)
+		expectedPath string
+		body         string
+		expected     any
+	}{
+		{
+			name: "BuildInfo",
+			call: func(c *CoderClient) (any, error) {
+				return c.BuildInfo(ctx)
+			},
+			expectedPath: "/api/v2/buildinfo",
+			body:         `{"version": "v2.20.0", "external_url": "https://github.com/coder/coder"}`,
+			expected:     &CoderBuildInfoResponse{Version: "v2.20.0", ExternalURL: "https://github.com/coder/coder"},
+		},
+		{
+			name: "GetUser",
+			call: func(c *CoderClient) (any, error) {
+				return c.GetUser(ctx, "me")
+			},
+			expectedPath: "/api/v2/users/me",
+			body:         `{"id": "id-1", "username": "alice", "email": "alice@example.com", "status": "active", "login_type": "oidc", "roles": [{"name": "owner", "display_name": "Owner"}], "created_at": "2024-01-02T03:04:05Z", "updated_at": "2024-02-03T04:05:06Z", "last_seen_at": "2024-03-04T05:06:07Z"}`,
+			expected: &CoderUser{
+				ID: "id-1", Username: "alice", Email: "alice@example.com", Status: UserStatusActive, LoginType: LoginTypeOIDC,
+				Roles:      []CoderRole{{Name: RoleOwner, DisplayName: "Owner"}},
+				CreatedAt:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				UpdatedAt:  time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC),
+				LastSeenAt: time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC),
+			},
+		},
+		{
+			name: "GetUserLoginType",
+			call: func(c *CoderClient) (any, error) {
+				return c.GetUserLoginType(ctx, "id-1")
+			},
+			expectedPath: "/api/v2/users/id-1/login-type",
+			body:         `{"login_type": "oidc"}`,
+			expected:     LoginTypeOIDC,
+		},
+		{
+			name: "GetGitSSHKey",
+			call: func(c *CoderClient) (any, error) {
+				return c.GetGitSSHKey(ctx, "id-1")
+			},
+			expectedPath: "/api/v2/users/id-1/gitsshkey",
+			body:         `{"user_id": "id-1", "public_key": "ssh-ed25519 AAAA"}`,
+			expected:     &CoderUserGitSSHKeyResponse{UserID: "id-1", PublicKey: "ssh-ed25519 AAAA"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tc.expectedPath {
+					t.Errorf("got path %q, want %q", r.URL.Path, tc.expectedPath)
+				}
+				fmt.Fprintln(w, tc.body)
+			}))
+			defer server.Close()
+
+			got, err := tc.call(NewCoderClient(server.URL, "test-token"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
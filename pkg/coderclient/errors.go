@@ -0,0 +1,104 @@
+package coderclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBodySize limits how much of an error response body is read.
+const maxErrorBodySize = 64 << 10
+
+// Error is returned when Coder API responds with an unexpected status code.
+// Use errors.As to access it, or the IsNotFound, IsUnauthorized and
+// IsRateLimited helpers for the common cases.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int `json:"-"`
+
+	// Status is the HTTP status line of the response, such as "404 Not Found".
+	Status string `json:"-"`
+
+	// Method and URL identify the failed request.
+	Method string `json:"-"`
+	URL    string `json:"-"`
+
+	// Message, Detail and Validations are decoded from the Coder error
+	// response body. Message holds the raw body when it is not JSON.
+	Message     string            `json:"message"`
+	Detail      string            `json:"detail,omitempty"`
+	Validations []ValidationError `json:"validations,omitempty"`
+}
+
+// ValidationError describes why a field of a Coder API request is invalid.
+type ValidationError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Coder HTTP status: %s: %s %s", e.Status, e.Method, e.URL)
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	if e.Detail != "" {
+		fmt.Fprintf(&b, ": %s", e.Detail)
+	}
+	for _, v := range e.Validations {
+		fmt.Fprintf(&b, "; %s: %s", v.Field, v.Detail)
+	}
+	return b.String()
+}
+
+// newError builds an *Error from resp, consuming its body.
+func newError(resp *http.Response) *Error {
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil || len(body) == 0 {
+		return e
+	}
+	if err := json.Unmarshal(body, e); err != nil {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// hasStatus reports whether err is an *Error with one of codes.
+func hasStatus(err error, codes ...int) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, code := range codes {
+		if e.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound reports whether err is a Coder API not found error.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized reports whether err is a Coder API error caused by missing,
+// invalid or insufficient credentials.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized, http.StatusForbidden)
+}
+
+// IsRateLimited reports whether err is a Coder API rate limiting error.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
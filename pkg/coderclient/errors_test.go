@@ -0,0 +1,126 @@
+package coderclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestError(t *testing.T) {
+	testCases := []struct {
+		name         string
+		status       int
+		body         string
+		expected     *Error
+		notFound     bool
+		unauthorized bool
+		rateLimited  bool
+	}{
+		{
+			name:   "Not_found_with_message",
+			status: http.StatusNotFound,
+			body:   `{"message": "Resource not found or you do not have access to this resource"}`,
+			expected: &Error{
+				StatusCode: http.StatusNotFound,
+				Status:     "404 Not Found",
+				Method:     http.MethodGet,
+				Message:    "Resource not found or you do not have access to this resource",
+			},
+			notFound: true,
+		},
+		{
+			name:   "Unauthorized_with_detail",
+			status: http.StatusUnauthorized,
+			body:   `{"message": "You must be logged in.", "detail": "Session token is expired."}`,
+			expected: &Error{
+				StatusCode: http.StatusUnauthorized,
+				Status:     "401 Unauthorized",
+				Method:     http.MethodGet,
+				Message:    "You must be logged in.",
+				Detail:     "Session token is expired.",
+			},
+			unauthorized: true,
+		},
+		{
+			name:   "Bad_request_with_validations",
+			status: http.StatusBadRequest,
+			body:   `{"message": "Invalid query.", "validations": [{"field": "q", "detail": "unknown status"}]}`,
+			expected: &Error{
+				StatusCode:  http.StatusBadRequest,
+				Status:      "400 Bad Request",
+				Method:      http.MethodGet,
+				Message:     "Invalid query.",
+				Validations: []ValidationError{{Field: "q", Detail: "unknown status"}},
+			},
+		},
+		{
+			name:   "Rate_limited_plain_text",
+			status: http.StatusTooManyRequests,
+			body:   "slow down\n",
+			expected: &Error{
+				StatusCode: http.StatusTooManyRequests,
+				Status:     "429 Too Many Requests",
+				Method:     http.MethodGet,
+				Message:    "slow down",
+			},
+			rateLimited: true,
+		},
+		{
+			name:   "Empty_body",
+			status: http.StatusBadGateway,
+			expected: &Error{
+				StatusCode: http.StatusBadGateway,
+				Status:     "502 Bad Gateway",
+				Method:     http.MethodGet,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			}))
+			defer server.Close()
+
+			client := NewCoderClient(server.URL, "test-token")
+			_, err := client.GetUser(context.Background(), "me")
+
+			var got *Error
+			if !errors.As(fmt.Errorf("wrapped: %w", err), &got) {
+				t.Fatalf("error %v is not an *Error", err)
+			}
+			tc.expected.URL = server.URL + "/api/v2/users/me"
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("error mismatch (-want +got):\n%s", diff)
+			}
+			if !strings.Contains(err.Error(), tc.expected.Status) {
+				t.Errorf("error message %q does not contain status", err)
+			}
+
+			if IsNotFound(err) != tc.notFound {
+				t.Errorf("IsNotFound() = %v, want %v", IsNotFound(err), tc.notFound)
+			}
+			if IsUnauthorized(err) != tc.unauthorized {
+				t.Errorf("IsUnauthorized() = %v, want %v", IsUnauthorized(err), tc.unauthorized)
+			}
+			if IsRateLimited(err) != tc.rateLimited {
+				t.Errorf("IsRateLimited() = %v, want %v", IsRateLimited(err), tc.rateLimited)
+			}
+		})
+	}
+}
+
+func TestErrorHelpersOnOtherErrors(t *testing.T) {
+	err := errors.New("connection refused")
+	if IsNotFound(err) || IsUnauthorized(err) || IsRateLimited(err) {
+		t.Errorf("helpers matched a non-Coder error")
+	}
+}
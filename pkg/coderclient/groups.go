@@ -0,0 +1,85 @@
+package coderclient
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// CoderGroup represents a Coder group and its members.
+type CoderGroup struct {
+	ID               string             `json:"id"`
+	Name             string             `json:"name"`
+	DisplayName      string             `json:"display_name,omitempty"`
+	OrganizationID   string             `json:"organization_id"`
+	OrganizationName string             `json:"organization_name,omitempty"`
+	Members          []CoderReducedUser `json:"members"`
+	TotalMemberCount int                `json:"total_member_count"`
+	Source           string             `json:"source,omitempty"`
+}
+
+// CoderReducedUser is the subset of user details embedded in other resources,
+// such as group members.
+type CoderReducedUser struct {
+	ID       string     `json:"id"`
+	Username string     `json:"username"`
+	Email    string     `json:"email"`
+	Status   UserStatus `json:"status"`
+}
+
+// CoderOrganization represents a Coder organization.
+type CoderOrganization struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	IsDefault   bool   `json:"is_default"`
+}
+
+// ListGroupsOptions specifies parameters for listing Coder groups.
+type ListGroupsOptions struct {
+	// Organization restricts groups to the organization with this ID or name.
+	Organization string
+
+	// HasMember restricts groups to those the user with this ID or username
+	// is a member of.
+	HasMember string
+
+	// GroupIDs restricts groups to those with these IDs.
+	GroupIDs []string
+}
+
+// values returns opts as query parameters of the groups endpoint.
+func (opts *ListGroupsOptions) values() url.Values {
+	v := url.Values{}
+	if opts == nil {
+		return v
+	}
+	if opts.Organization != "" {
+		v.Set("organization", opts.Organization)
+	}
+	if opts.HasMember != "" {
+		v.Set("has_member", opts.HasMember)
+	}
+	if len(opts.GroupIDs) > 0 {
+		v.Set("group_ids", strings.Join(opts.GroupIDs, ","))
+	}
+	return v
+}
+
+// ListGroups returns the groups matching opts, including their members.
+func (c *CoderClient) ListGroups(ctx context.Context, opts *ListGroupsOptions) ([]CoderGroup, error) {
+	var groups []CoderGroup
+	if err := c.get(ctx, "/api/v2/groups", opts.values(), &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// ListOrganizations returns every organization of the Coder deployment.
+func (c *CoderClient) ListOrganizations(ctx context.Context) ([]CoderOrganization, error) {
+	var orgs []CoderOrganization
+	if err := c.get(ctx, "/api/v2/organizations", nil, &orgs); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
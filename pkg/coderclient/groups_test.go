@@ -0,0 +1,67 @@
+package coderclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListGroups(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/groups" {
+			t.Errorf("got path %q, want %q", r.URL.Path, "/api/v2/groups")
+		}
+		q := r.URL.Query()
+		if q.Get("organization") != "default" || q.Get("has_member") != "alice" || q.Get("group_ids") != "g1,g2" {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+		}
+		fmt.Fprintln(w, `[{"id": "g1", "name": "gerrit-contributors", "organization_id": "o1", "members": [{"id": "id-1", "username": "alice"}], "total_member_count": 1}]`)
+	}))
+	defer server.Close()
+
+	client := NewCoderClient(server.URL, "test-token")
+	got, err := client.ListGroups(context.Background(), &ListGroupsOptions{
+		Organization: "default",
+		HasMember:    "alice",
+		GroupIDs:     []string{"g1", "g2"},
+	})
+	if err != nil {
+		t.Fatalf("ListGroups() error = %v", err)
+	}
+
+	expected := []CoderGroup{{
+		ID:               "g1",
+		Name:             "gerrit-contributors",
+		OrganizationID:   "o1",
+		Members:          []CoderReducedUser{{ID: "id-1", Username: "alice"}},
+		TotalMemberCount: 1,
+	}}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("ListGroups() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestListOrganizations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/organizations" {
+			t.Errorf("got path %q, want %q", r.URL.Path, "/api/v2/organizations")
+		}
+		fmt.Fprintln(w, `[{"id": "o1", "name": "coder", "is_default": true}]`)
+	}))
+	defer server.Close()
+
+	client := NewCoderClient(server.URL, "test-token")
+	got, err := client.ListOrganizations(context.Background())
+	if err != nil {
+		t.Fatalf("ListOrganizations() error = %v", err)
+	}
+
+	expected := []CoderOrganization{{ID: "o1", Name: "coder", IsDefault: true}}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("ListOrganizations() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,42 @@
+package coderclient
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CoderAPIKey describes a Coder session or API token, without its secret.
+type CoderAPIKey struct {
+	ID              string    `json:"id"`
+	UserID          string    `json:"user_id"`
+	LastUsed        time.Time `json:"last_used"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	LoginType       LoginType `json:"login_type"`
+	Scope           string    `json:"scope,omitempty"`
+	TokenName       string    `json:"token_name,omitempty"`
+	LifetimeSeconds int64     `json:"lifetime_seconds"`
+}
+
+// APIKeyID returns the ID of the API key of token, which Coder formats as
+// "<id>-<secret>", or "" if token is not formatted so.
+func APIKeyID(token string) string {
+	id, secret, ok := strings.Cut(token, "-")
+	if !ok || id == "" || secret == "" {
+		return ""
+	}
+	return id
+}
+
+// GetAPIKey returns the API key with ID keyID of the user identified by user,
+// which is either a user ID, a username or "me" for the authenticated user.
+func (c *CoderClient) GetAPIKey(ctx context.Context, user, keyID string) (*CoderAPIKey, error) {
+	var key CoderAPIKey
+	if err := c.get(ctx, "/api/v2/users/"+url.PathEscape(user)+"/keys/"+url.PathEscape(keyID), nil, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -0,0 +1,59 @@
+package coderclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAPIKeyID(t *testing.T) {
+	testCases := []struct {
+		token    string
+		expected string
+	}{
+		{token: "abcdefghij-0123456789abcdefghijkl", expected: "abcdefghij"},
+		{token: "opaque", expected: ""},
+		{token: "-secret", expected: ""},
+		{token: "id-", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		if got := APIKeyID(tc.token); got != tc.expected {
+			t.Errorf("APIKeyID(%q) = %q, want %q", tc.token, got, tc.expected)
+		}
+	}
+}
+
+func TestGetAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/users/me/keys/abcdefghij" {
+			t.Errorf("got path %q, want %q", r.URL.Path, "/api/v2/users/me/keys/abcdefghij")
+		}
+		fmt.Fprintln(w, `{"id": "abcdefghij", "user_id": "id-1", "expires_at": "2026-01-02T03:04:05Z", "login_type": "token", "scope": "all", "token_name": "gerrit-sync", "lifetime_seconds": 2592000}`)
+	}))
+	defer server.Close()
+
+	client := NewCoderClient(server.URL, "test-token")
+	got, err := client.GetAPIKey(context.Background(), "me", "abcdefghij")
+	if err != nil {
+		t.Fatalf("GetAPIKey() error = %v", err)
+	}
+
+	expected := &CoderAPIKey{
+		ID:              "abcdefghij",
+		UserID:          "id-1",
+		ExpiresAt:       time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		LoginType:       LoginTypeToken,
+		Scope:           "all",
+		TokenName:       "gerrit-sync",
+		LifetimeSeconds: 2592000,
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("GetAPIKey() mismatch (-want +got):\n%s", diff)
+	}
+}
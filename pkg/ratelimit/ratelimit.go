@@ -0,0 +1,80 @@
+// Package ratelimit provides a simple request rate limiter and an
+// http.RoundTripper applying it.
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter spaces out events so that at most a fixed number of them happen per
+// second. It is safe for concurrent use. A nil *Limiter does not limit.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter returns a Limiter allowing qps events per second, or nil, which
+// does not limit, if qps is not positive.
+func NewLimiter(qps float64) *Limiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &Limiter{interval: time.Duration(float64(time.Second) / qps)}
+}
+
+// reserve returns how long the caller has to wait for its turn at now.
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return wait
+}
+
+// Wait blocks until the caller may proceed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	wait := l.reserve(time.Now())
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Transport is an http.RoundTripper sending requests no faster than Limiter
+// allows.
+type Transport struct {
+	// Base performs the requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Limiter paces the requests.
+	Limiter *Limiter
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	l := NewLimiter(10)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expected := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond}
+	for i, want := range expected {
+		if got := l.reserve(now); got != want {
+			t.Errorf("reserve() #%d = %v, want %v", i, got, want)
+		}
+	}
+
+	// After an idle period, the next event proceeds immediately.
+	if got := l.reserve(now.Add(time.Second)); got != 0 {
+		t.Errorf("reserve() after idle = %v, want 0", got)
+	}
+}
+
+func TestNewLimiterUnlimited(t *testing.T) {
+	for _, qps := range []float64{0, -1} {
+		l := NewLimiter(qps)
+		if l != nil {
+			t.Errorf("NewLimiter(%v) = %v, want nil", qps, l)
+		}
+		if err := l.Wait(context.Background()); err != nil {
+			t.Errorf("nil Limiter Wait() error = %v", err)
+		}
+	}
+}
+
+func TestLimiterWaitCancelled(t *testing.T) {
+	l := NewLimiter(0.001)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := l.Wait(ctx); err != nil {
+		t.Fatalf("first Wait() error = %v", err)
+	}
+	cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Errorf("Wait() with cancelled context succeeded")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{Limiter: NewLimiter(100)}}
+	start := time.Now()
+	for range 3 {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("3 requests at 100 qps took %v, want at least 20ms", elapsed)
+	}
+}
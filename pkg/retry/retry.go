@@ -0,0 +1,232 @@
+// Package retry provides an http.RoundTripper that retries failed requests
+// with exponential backoff.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Policy describes how failed requests are retried.
+type Policy struct {
+	// MaxAttempts is the maximum number of attempts of a request, including
+	// the first one. Values below 2 disable retries.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry. It doubles with every
+	// further retry.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between attempts, including delays requested
+	// by the server with Retry-After. A request is not retried if the server
+	// asks to wait longer than this.
+	MaxDelay time.Duration
+
+	// Jitter randomly shortens each backoff delay by up to this fraction of
+	// it, so that clients failing together do not retry together.
+	Jitter float64
+}
+
+// DefaultPolicy returns the policy used when none is configured.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: 4,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// backoff returns the delay before retry number retry, counting from 1.
+func (p Policy) backoff(retry int, r func() float64) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxDelay)
+	if p.Jitter > 0 {
+		d -= time.Duration(float64(d) * p.Jitter * r())
+	}
+	return d
+}
+
+// Transport is an http.RoundTripper retrying requests according to Policy.
+//
+// Network errors and 502, 503 and 504 responses are retried for idempotent
+// methods only, since a non-idempotent request such as a POST may have been
+// applied before failing. 429 responses, and 503 responses carrying
+// Retry-After, mean the server refused the request, and are retried for every
+// method. Retry-After is honored when present.
+type Transport struct {
+	// Base performs the requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Policy controls the number of attempts and the delays between them.
+	Policy Policy
+
+	// AttemptTimeout limits each attempt, including the read of its response
+	// body, so that a hanging attempt is retried like a failed one. Delays
+	// between attempts are not limited. Attempts are unlimited if zero.
+	AttemptTimeout time.Duration
+
+	// Logf, if set, is called before every retry.
+	Logf func(format string, args ...any)
+
+	// sleep waits for d or until ctx is done. It is replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewTransport returns a Transport retrying requests made with base
+// according to policy.
+func NewTransport(base http.RoundTripper, policy Policy) *Transport {
+	return &Transport{Base: base, Policy: policy}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	sleep := t.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(base, req)
+		if attempt >= t.Policy.MaxAttempts {
+			return resp, err
+		}
+
+		delay, retry := t.shouldRetry(req, resp, err, attempt)
+		if !retry {
+			return resp, err
+		}
+		// Requests with a body can only be retried if it can be read again.
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		reason := fmt.Sprint(err)
+		if resp != nil {
+			reason = resp.Status
+			// Drain the body so that the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if t.Logf != nil {
+			t.Logf("Retrying %s %s in %v (attempt %d/%d): %s", req.Method, req.URL.Redacted(), delay, attempt+1, t.Policy.MaxAttempts, reason)
+		}
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt makes a single attempt of req with base, within AttemptTimeout.
+func (t *Transport) attempt(base http.RoundTripper, req *http.Request) (*http.Response, error) {
+	if t.AttemptTimeout <= 0 {
+		return base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.AttemptTimeout)
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The deadline lasts until the body is closed.
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody is a response body cancelling the context of its attempt when
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// shouldRetry decides whether the outcome of attempt of req is retried, and
+// after which delay.
+func (t *Transport) shouldRetry(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if req.Context().Err() != nil {
+		return 0, false
+	}
+	delay := t.Policy.backoff(attempt, rand.Float64)
+
+	if err != nil {
+		return delay, isIdempotent(req.Method)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		if after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if after > t.Policy.MaxDelay {
+				return 0, false
+			}
+			return after, true
+		}
+		return delay, resp.StatusCode == http.StatusTooManyRequests || isIdempotent(req.Method)
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return delay, isIdempotent(req.Method)
+	default:
+		return 0, false
+	}
+}
+
+// isIdempotent reports whether requests with method can be safely repeated.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date, relative to now.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d or until ctx is done, whichever happens first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,259 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	testCases := []struct {
+		name             string
+		method           string
+		statuses         []int
+		retryAfter       string
+		expectedAttempts int
+		expectedStatus   int
+		expectedDelays   []time.Duration
+	}{
+		{
+			name:             "Success_first_attempt",
+			method:           http.MethodGet,
+			statuses:         []int{http.StatusOK},
+			expectedAttempts: 1,
+			expectedStatus:   http.StatusOK,
+		},
+		{
+			name:             "GET_retried_on_502",
+			method:           http.MethodGet,
+			statuses:         []int{http.StatusBadGateway, http.StatusGatewayTimeout, http.StatusOK},
+			expectedAttempts: 3,
+			expectedStatus:   http.StatusOK,
+			expectedDelays:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:             "GET_gives_up_after_max_attempts",
+			method:           http.MethodGet,
+			statuses:         []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			expectedAttempts: 3,
+			expectedStatus:   http.StatusBadGateway,
+			expectedDelays:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:             "POST_not_retried_on_502",
+			method:           http.MethodPost,
+			statuses:         []int{http.StatusBadGateway, http.StatusOK},
+			expectedAttempts: 1,
+			expectedStatus:   http.StatusBadGateway,
+		},
+		{
+			name:             "POST_retried_on_429",
+			method:           http.MethodPost,
+			statuses:         []int{http.StatusTooManyRequests, http.StatusCreated},
+			expectedAttempts: 2,
+			expectedStatus:   http.StatusCreated,
+			expectedDelays:   []time.Duration{100 * time.Millisecond},
+		},
+		{
+			name:             "POST_retried_on_503_with_Retry_After",
+			method:           http.MethodPost,
+			statuses:         []int{http.StatusServiceUnavailable, http.StatusCreated},
+			retryAfter:       "2",
+			expectedAttempts: 2,
+			expectedStatus:   http.StatusCreated,
+			expectedDelays:   []time.Duration{2 * time.Second},
+		},
+		{
+			name:             "Retry_After_beyond_max_delay",
+			method:           http.MethodGet,
+			statuses:         []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:       "3600",
+			expectedAttempts: 1,
+			expectedStatus:   http.StatusTooManyRequests,
+		},
+		{
+			name:             "Client_error_not_retried",
+			method:           http.MethodGet,
+			statuses:         []int{http.StatusNotFound, http.StatusOK},
+			expectedAttempts: 1,
+			expectedStatus:   http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if tc.method == http.MethodPost && string(body) != "payload" {
+					t.Errorf("attempt %d got body %q, want %q", attempts+1, body, "payload")
+				}
+				status := tc.statuses[min(attempts, len(tc.statuses)-1)]
+				attempts++
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			var delays []time.Duration
+			transport := NewTransport(nil, Policy{
+				MaxAttempts: 3,
+				BaseDelay:   100 * time.Millisecond,
+				MaxDelay:    time.Minute,
+			})
+			transport.sleep = func(ctx context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			req, err := http.NewRequest(tc.method, server.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+			resp, err := (&http.Client{Transport: transport}).Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.expectedStatus)
+			}
+			if attempts != tc.expectedAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, tc.expectedAttempts)
+			}
+			if len(delays) != len(tc.expectedDelays) {
+				t.Fatalf("got delays %v, want %v", delays, tc.expectedDelays)
+			}
+			for i := range delays {
+				if delays[i] != tc.expectedDelays[i] {
+					t.Errorf("got delays %v, want %v", delays, tc.expectedDelays)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Jitter: 0.5}
+
+	testCases := []struct {
+		retry    int
+		random   float64
+		expected time.Duration
+	}{
+		{retry: 1, random: 0, expected: time.Second},
+		{retry: 2, random: 0, expected: 2 * time.Second},
+		{retry: 3, random: 0, expected: 4 * time.Second},
+		{retry: 4, random: 0, expected: 5 * time.Second},
+		{retry: 50, random: 0, expected: 5 * time.Second},
+		{retry: 2, random: 1, expected: time.Second},
+	}
+
+	for _, tc := range testCases {
+		if got := p.backoff(tc.retry, func() float64 { return tc.random }); got != tc.expected {
+			t.Errorf("backoff(%d) with random %v = %v, want %v", tc.retry, tc.random, got, tc.expected)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{value: "", ok: false},
+		{value: "30", expected: 30 * time.Second, ok: true},
+		{value: now.Add(time.Minute).Format(http.TimeFormat), expected: time.Minute, ok: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), expected: 0, ok: true},
+		{value: "soon", ok: false},
+	}
+
+	for _, tc := range testCases {
+		got, ok := retryAfter(tc.value, now)
+		if got != tc.expected || ok != tc.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tc.value, got, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestTransportNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	sleeps := 0
+	transport := NewTransport(nil, Policy{MaxAttempts: 3})
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps++
+		return nil
+	}
+	client := &http.Client{Transport: transport}
+
+	if _, err := client.Get(url); err == nil {
+		t.Fatalf("Get() succeeded against a closed server")
+	}
+	if sleeps != 2 {
+		t.Errorf("GET retried %d times, want 2", sleeps)
+	}
+
+	sleeps = 0
+	if _, err := client.Post(url, "text/plain", strings.NewReader("payload")); err == nil {
+		t.Fatalf("Post() succeeded against a closed server")
+	}
+	if sleeps != 0 {
+		t.Errorf("POST retried %d times, want 0", sleeps)
+	}
+}
+
+func TestTransportAttemptTimeout(t *testing.T) {
+	done := make(chan struct{})
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+	defer close(done)
+
+	sleeps := 0
+	transport := NewTransport(nil, Policy{MaxAttempts: 2})
+	transport.AttemptTimeout = 50 * time.Millisecond
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps++
+		return nil
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+	// The body is read after the attempt returned, within its deadline.
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(body) != "ok" {
+		t.Errorf("got body %q, want %q", body, "ok")
+	}
+	if sleeps != 1 {
+		t.Errorf("GET retried %d times after a hanging attempt, want 1", sleeps)
+	}
+}